@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/fatih/color"
+	"repo-concat/concat"
 )
 
 // Minimal, elegant styling functions that enhance without overwhelming
@@ -58,44 +59,32 @@ func Progress(current, total int, message string) string {
 		blue.Sprint("•"), message, percentage, current, total)
 }
 
-// Elegant tree display with minimal borders
+// Elegant tree display with minimal borders, built with the same tree as
+// the directory tree in the output
 func SimpleTree(rootPath string, files []string, excluded []string) string {
 	var lines []string
 	
 	// Just show a clean, simple tree with meaningful name
 	lines = append(lines, Info("📁 " + rootPath))
 	
-	// Group files by directory
-	dirs := make(map[string][]string)
-	for _, file := range files {
-		parts := strings.Split(file, "/")
-		if len(parts) > 1 {
-			dir := parts[0]
-			filename := strings.Join(parts[1:], "/")
-			dirs[dir] = append(dirs[dir], filename)
-		} else {
-			dirs["."] = append(dirs["."], file)
-		}
-	}
-	
-	// Show directories and files cleanly
-	for dir, dirFiles := range dirs {
-		if dir != "." {
-			lines = append(lines, "  " + cyan.Sprint("📁 " + dir + "/"))
-			for _, file := range dirFiles {
-				icon := getSimpleIcon(file)
-				lines = append(lines, "    " + gray.Sprint(icon + " " + file))
+	// Show directories first, then files, nested by depth
+	var walk func(node *concat.PathNode, indent string)
+	walk = func(node *concat.PathNode, indent string) {
+		for _, child := range node.Children {
+			if child.IsDir() {
+				lines = append(lines, indent + cyan.Sprint("📁 " + child.Name + "/"))
+				walk(child, indent + "  ")
+				continue
+			}
+			icon := getSimpleIcon(child.Name)
+			if indent == "  " {
+				lines = append(lines, indent + white.Sprint(icon + " " + child.Name))
+			} else {
+				lines = append(lines, indent + gray.Sprint(icon + " " + child.Name))
 			}
 		}
 	}
-	
-	// Show root files
-	if rootFiles, ok := dirs["."]; ok {
-		for _, file := range rootFiles {
-			icon := getSimpleIcon(file)
-			lines = append(lines, "  " + white.Sprint(icon + " " + file))
-		}
-	}
+	walk(concat.BuildPathTree(files), "  ")
 	
 	return strings.Join(lines, "\n")
 }
@@ -111,13 +100,17 @@ func getSimpleIcon(filename string) string {
 	return "📄"
 }
 
-// Simple summary table (no heavy borders)
-func SimpleSummary(included, excluded, totalSize int64) string {
+// Simple summary table (no heavy borders), with the excluded files broken
+// down by reason
+func SimpleSummary(included, excluded, totalSize int64, reasons []concat.ReasonCount) string {
 	var lines []string
 	
 	lines = append(lines, bold.Sprint("Summary:"))
 	lines = append(lines, fmt.Sprintf("  Files to include: %s", green.Sprint(fmt.Sprintf("%d", included))))
 	lines = append(lines, fmt.Sprintf("  Files excluded:   %s", gray.Sprint(fmt.Sprintf("%d", excluded))))
+	for _, reason := range reasons {
+		lines = append(lines, gray.Sprintf("    %6d  %s", reason.Count, reason.Reason))
+	}
 	if totalSize > 0 {
 		lines = append(lines, fmt.Sprintf("  Total size:       %s", gray.Sprint(formatSize(totalSize))))
 	}
@@ -0,0 +1,547 @@
+package concat
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveExtensions maps supported archive suffixes to their decompressor
+var archiveExtensions = []struct {
+	suffix     string
+	decompress func(io.Reader) (io.ReadCloser, error)
+}{
+	{".zip", nil},
+	{".tar.gz", gunzip},
+	{".tgz", gunzip},
+	{".tar.zst", unzstd},
+	{".tzst", unzstd},
+	{".tar", uncompressed},
+}
+
+// IsArchive reports whether path names a supported archive.
+func IsArchive(path string) bool {
+	_, ok := archiveSuffix(path)
+	return ok
+}
+
+func archiveSuffix(p string) (string, bool) {
+	lower := strings.ToLower(p)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(lower, ext.suffix) {
+			return ext.suffix, true
+		}
+	}
+	return "", false
+}
+
+// ArchiveSource is a .zip, .tar, .tar.gz or .tar.zst file. Entries are read
+// straight from the archive without extracting it to disk.
+type ArchiveSource struct {
+	Path string
+}
+
+// Resolve implements Source.
+func (s ArchiveSource) Resolve() (*Tree, error) {
+	suffix, ok := archiveSuffix(s.Path)
+	if !ok {
+		return nil, fmt.Errorf("unsupported archive format: %s", s.Path)
+	}
+	if _, err := os.Stat(s.Path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("archive does not exist: %s", s.Path)
+	}
+
+	base := filepath.Base(s.Path)
+	tree := &Tree{
+		SourceInfo: SourceInfo{
+			Name:    base[:len(base)-len(suffix)],
+			Path:    s.Path,
+			Archive: base,
+		},
+	}
+
+	if suffix == ".zip" {
+		reader, err := zip.OpenReader(s.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+		tree.FS = reader
+		tree.cleanup = reader.Close
+		return tree, nil
+	}
+
+	for _, ext := range archiveExtensions {
+		if ext.suffix == suffix {
+			fsys, err := newTarFS(s.Path, ext.decompress)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read archive: %w", err)
+			}
+			tree.FS = fsys
+			tree.cleanup = fsys.Close
+			break
+		}
+	}
+	return tree, nil
+}
+
+func gunzip(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func unzstd(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
+
+// uncompressed keeps a plain tar file seekable, so that tar.Reader seeks
+// past the entries it skips instead of reading them
+func uncompressed(r io.Reader) (io.ReadCloser, error) {
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		return nopSeekCloser{seeker}, nil
+	}
+	return io.NopCloser(r), nil
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+const (
+	// tarIdleStreams is how many open streams a tarFS keeps for reuse.
+	// Each holds a decompressor, so it is kept near the number of parallel
+	// readers.
+	tarIdleStreams = 16
+	// tarCacheSize bounds the content a tarFS keeps of the files it was
+	// told will be read, and tarCacheEntryLimit the size of such a file.
+	// Larger files are streamed each time.
+	tarCacheSize       = 64 << 20
+	tarCacheEntryLimit = tarCacheSize / 8
+)
+
+// tarFS is a read-only fs.FS over a tar stream. A single pass indexes the
+// headers, and files are streamed from the archive as they are read.
+// Compressed streams cannot seek, so streams are kept open after a file is
+// closed and reused for later entries. Files are rarely read in archive
+// order, so the files a scan will read (see willRead) are kept in a
+// bounded cache as streams pass them: a scan then takes about one pass
+// over the archive rather than one per file.
+type tarFS struct {
+	path       string
+	decompress func(io.Reader) (io.ReadCloser, error)
+	files      map[string]*tarEntry
+	dirs       map[string][]fs.DirEntry
+
+	mu sync.Mutex
+	// idle holds the streams no file is reading, least recently used first
+	idle []*tarStream
+	// wanted marks the entries, by index, that will be read
+	wanted map[int]bool
+	// cache holds the content of wanted entries, least recently used at
+	// the front of recent
+	cache      map[int]*list.Element
+	recent     *list.List
+	cacheBytes int64
+}
+
+// cached is the content of an entry in the cache
+type cached struct {
+	index int
+	data  []byte
+}
+
+type tarEntry struct {
+	header *tar.Header
+	index  int
+}
+
+func newTarFS(archivePath string, decompress func(io.Reader) (io.ReadCloser, error)) (*tarFS, error) {
+	fsys := &tarFS{
+		path:       archivePath,
+		decompress: decompress,
+		files:      make(map[string]*tarEntry),
+		dirs:       map[string][]fs.DirEntry{".": nil},
+		wanted:     make(map[int]bool),
+		cache:      make(map[int]*list.Element),
+		recent:     list.New(),
+	}
+
+	stream, err := fsys.openStream()
+	if err != nil {
+		return nil, err
+	}
+	defer stream.close()
+	for {
+		header, err := stream.reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		index := stream.next
+		stream.next++
+
+		name, ok := cleanArchivePath(header.Name)
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if _, exists := fsys.files[name]; !exists {
+			fsys.addDir(path.Dir(name))
+			fsys.dirs[path.Dir(name)] = append(fsys.dirs[path.Dir(name)], fs.FileInfoToDirEntry(tarFileInfo{header, path.Base(name)}))
+		}
+		fsys.files[name] = &tarEntry{header: header, index: index}
+	}
+
+	for dir := range fsys.dirs {
+		entries := fsys.dirs[dir]
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	}
+	return fsys, nil
+}
+
+// tarStream is a pass over the archive
+type tarStream struct {
+	file         *os.File
+	decompressed io.ReadCloser
+	reader       *tar.Reader
+	// next is the index of the entry reader.Next returns
+	next int
+}
+
+func (t *tarFS) openStream() (*tarStream, error) {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	decompressed, err := t.decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &tarStream{file: file, decompressed: decompressed, reader: tar.NewReader(decompressed)}, nil
+}
+
+func (s *tarStream) close() {
+	s.decompressed.Close()
+	s.file.Close()
+}
+
+// willRead tells the file system which files are about to be read, so
+// that their content is kept as streams pass them.
+func (t *tarFS) willRead(names []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, name := range names {
+		if entry, ok := t.files[name]; ok && entry.header.Size <= tarCacheEntryLimit {
+			t.wanted[entry.index] = true
+		}
+	}
+}
+
+// seek advances a stream to the entry at index, whose content the reader
+// then returns. Wanted entries on the way are cached.
+func (t *tarFS) seek(stream *tarStream, index int) error {
+	for stream.next <= index {
+		if _, err := stream.reader.Next(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		passed := stream.next
+		stream.next++
+		if passed < index && t.uncached(passed) {
+			data, err := io.ReadAll(stream.reader)
+			if err != nil {
+				return err
+			}
+			t.store(passed, data)
+		}
+	}
+	return nil
+}
+
+// uncached reports whether a wanted entry is missing from the cache
+func (t *tarFS) uncached(index int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.wanted[index] && t.cache[index] == nil
+}
+
+// lookup returns the cached content of an entry
+func (t *tarFS) lookup(index int) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	element, ok := t.cache[index]
+	if !ok {
+		return nil, false
+	}
+	t.recent.MoveToBack(element)
+	return element.Value.(*cached).data, true
+}
+
+// store caches the content of an entry, evicting the least recently used
+// entries beyond tarCacheSize
+func (t *tarFS) store(index int, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cache[index] != nil {
+		return
+	}
+	t.cache[index] = t.recent.PushBack(&cached{index, data})
+	t.cacheBytes += int64(len(data))
+	for t.cacheBytes > tarCacheSize {
+		oldest := t.recent.Remove(t.recent.Front()).(*cached)
+		delete(t.cache, oldest.index)
+		t.cacheBytes -= int64(len(oldest.data))
+	}
+}
+
+// acquire returns a stream positioned at the entry at index, reusing the
+// idle stream that is closest before it
+func (t *tarFS) acquire(index int) (*tarStream, error) {
+	t.mu.Lock()
+	best := -1
+	for i, s := range t.idle {
+		if s.next <= index && (best < 0 || s.next > t.idle[best].next) {
+			best = i
+		}
+	}
+	var stream *tarStream
+	if best >= 0 {
+		stream = t.idle[best]
+		t.idle = append(t.idle[:best], t.idle[best+1:]...)
+	}
+	t.mu.Unlock()
+
+	if stream == nil {
+		var err error
+		if stream, err = t.openStream(); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.seek(stream, index); err != nil {
+		stream.close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// release keeps a stream for reuse, closing the least recently used one
+// when there are too many
+func (t *tarFS) release(stream *tarStream) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.idle = append(t.idle, stream)
+	if len(t.idle) > tarIdleStreams {
+		t.idle[0].close()
+		t.idle = t.idle[1:]
+	}
+}
+
+// Close closes the idle streams.
+func (t *tarFS) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, stream := range t.idle {
+		stream.close()
+	}
+	t.idle = nil
+	return nil
+}
+
+// addDir records dir and its parents as implicit directories
+func (t *tarFS) addDir(dir string) {
+	if _, ok := t.dirs[dir]; ok {
+		return
+	}
+	parent := path.Dir(dir)
+	t.addDir(parent)
+	t.dirs[dir] = nil
+	t.dirs[parent] = append(t.dirs[parent], fs.FileInfoToDirEntry(dirInfo(path.Base(dir))))
+}
+
+// Open implements fs.FS. Unless it is cached, a file streams its content
+// from the archive, so reading the head of a file stops decompressing
+// after the head. Wanted files are read whole and cached.
+func (t *tarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if entries, ok := t.dirs[name]; ok {
+		return &memDir{info: dirInfo(path.Base(name)), entries: entries}, nil
+	}
+	entry, ok := t.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	info := tarFileInfo{entry.header, path.Base(name)}
+	if data, ok := t.lookup(entry.index); ok {
+		return &memFile{Reader: bytes.NewReader(data), info: info}, nil
+	}
+
+	stream, err := t.acquire(entry.index)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if !t.uncached(entry.index) {
+		return &tarFile{fsys: t, stream: stream, info: info}, nil
+	}
+	data, err := io.ReadAll(stream.reader)
+	if err != nil {
+		stream.close()
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	t.release(stream)
+	t.store(entry.index, data)
+	return &memFile{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (t *tarFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, ok := t.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]fs.DirEntry{}, entries...), nil
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (t *tarFS) ReadFile(name string) ([]byte, error) {
+	file, err := t.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// tarFile is an open archive entry, reading from a stream it holds until
+// it is closed
+type tarFile struct {
+	fsys   *tarFS
+	stream *tarStream
+	info   fs.FileInfo
+	err    error
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *tarFile) Read(p []byte) (int, error) {
+	if f.stream == nil {
+		return 0, &fs.PathError{Op: "read", Path: f.info.Name(), Err: fs.ErrClosed}
+	}
+	n, err := f.stream.reader.Read(p)
+	if err != nil && err != io.EOF {
+		f.err = err
+	}
+	return n, err
+}
+
+// Close hands the stream back for reuse, unless reading it failed
+func (f *tarFile) Close() error {
+	if f.stream == nil {
+		return &fs.PathError{Op: "close", Path: f.info.Name(), Err: fs.ErrClosed}
+	}
+	if f.err != nil {
+		f.stream.close()
+	} else {
+		f.fsys.release(f.stream)
+	}
+	f.stream = nil
+	return nil
+}
+
+// cleanArchivePath turns an archive entry name into an fs.FS path,
+// rejecting entries that would escape the archive root
+func cleanArchivePath(name string) (string, bool) {
+	name = path.Clean(strings.TrimLeft(strings.ReplaceAll(name, `\`, "/"), "/"))
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	return name, fs.ValidPath(name)
+}
+
+// tarFileInfo reports a tar header under its base name
+type tarFileInfo struct {
+	header *tar.Header
+	name   string
+}
+
+func (i tarFileInfo) Name() string       { return i.name }
+func (i tarFileInfo) Size() int64        { return i.header.Size }
+func (i tarFileInfo) Mode() fs.FileMode  { return i.header.FileInfo().Mode() }
+func (i tarFileInfo) ModTime() time.Time { return i.header.ModTime }
+func (i tarFileInfo) IsDir() bool        { return false }
+func (i tarFileInfo) Sys() any           { return i.header }
+
+// dirInfo describes an implicit archive directory
+type dirInfo string
+
+func (d dirInfo) Name() string       { return string(d) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() any           { return nil }
+
+// memFile is an open archive entry served from the cache
+type memFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+// memDir is an open archive directory
+type memDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return append([]fs.DirEntry{}, rest...), nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return append([]fs.DirEntry{}, rest[:n]...), nil
+}
@@ -0,0 +1,157 @@
+package concat
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// writeTar writes files to a tar archive at name, compressed by its suffix
+func writeTar(t *testing.T, name string, files []string, contents map[string]string) string {
+	t.Helper()
+	archive := filepath.Join(t.TempDir(), name)
+	out, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	var w io.WriteCloser = out
+	switch filepath.Ext(name) {
+	case ".gz":
+		w = gzip.NewWriter(out)
+	case ".zst":
+		if w, err = zstd.NewWriter(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw := tar.NewWriter(w)
+	for _, file := range files {
+		content := contents[file]
+		if err := tw.WriteHeader(&tar.Header{Name: file, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w != io.WriteCloser(out) {
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return archive
+}
+
+func TestTarFS(t *testing.T) {
+	contents := map[string]string{
+		"README.md":           "# readme\n",
+		"src/main.go":         "package main\n",
+		"src/big.txt":         string(bytes.Repeat([]byte("0123456789abcdef\n"), 16<<10)),
+		"src/lib/util.go":     "package lib\n",
+		"../escape.txt":       "outside\n",
+		"docs/guide/intro.md": "intro\n",
+	}
+	var names []string
+	for i := range 200 {
+		name := fmt.Sprintf("data/file%03d.txt", i)
+		contents[name] = fmt.Sprintf("file %d\n", i)
+		names = append(names, name)
+	}
+	order := append([]string{"README.md", "src/main.go", "src/big.txt", "../escape.txt", "src/lib/util.go", "docs/guide/intro.md"}, names...)
+
+	for _, name := range []string{"repo.tar", "repo.tar.gz", "repo.tar.zst"} {
+		t.Run(name, func(t *testing.T) {
+			tree, err := ArchiveSource{Path: writeTar(t, name, order, contents)}.Resolve()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+			fsys := tree.FS.(*tarFS)
+
+			expected := []string{"README.md", "src/main.go", "src/big.txt", "src/lib/util.go", "docs/guide/intro.md"}
+			if err := fstest.TestFS(fsys, append(expected, names...)...); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fs.Stat(fsys, "escape.txt"); err == nil {
+				t.Error("an entry outside the archive root was indexed")
+			}
+
+			// Reading in archive order reuses one stream
+			fsys.Close()
+			for _, file := range names {
+				data, err := fs.ReadFile(fsys, file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != contents[file] {
+					t.Fatalf("%s = %q, want %q", file, data, contents[file])
+				}
+			}
+			if len(fsys.idle) != 1 {
+				t.Errorf("reading in order left %d streams, want 1", len(fsys.idle))
+			}
+
+			// Wanted files are cached as a stream passes them, so reading
+			// them out of archive order takes one pass
+			fsys.Close()
+			fsys.willRead(names)
+			for i := len(names) - 1; i >= 0; i-- {
+				if data, err := fs.ReadFile(fsys, names[i]); err != nil || string(data) != contents[names[i]] {
+					t.Fatalf("%s = %q, %v", names[i], data, err)
+				}
+			}
+			if len(fsys.cache) != len(names) || len(fsys.idle) != 1 {
+				t.Errorf("reading in reverse cached %d files with %d streams, want %d with 1", len(fsys.cache), len(fsys.idle), len(names))
+			}
+
+			// Heads, whole files and random order from several readers
+			var wg sync.WaitGroup
+			for worker := range 4 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					files := append([]string{}, expected...)
+					files = append(files, names...)
+					rand.New(rand.NewSource(int64(worker))).Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+					for _, file := range files {
+						head, err := readHead(fsys, file, 16)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if want := contents[file][:min(16, len(contents[file]))]; string(head) != want {
+							t.Errorf("head of %s = %q, want %q", file, head, want)
+						}
+						data, err := fs.ReadFile(fsys, file)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if string(data) != contents[file] {
+							t.Errorf("%s has %d bytes, want %d", file, len(data), len(contents[file]))
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if len(fsys.idle) > tarIdleStreams {
+				t.Errorf("%d idle streams, want at most %d", len(fsys.idle), tarIdleStreams)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package concat
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Auth holds credentials for private repositories. Tokens are handed to
+// git through a credential helper that reads them from the environment, so
+// they never appear in URLs, command lines, cache metadata or output.
+type Auth struct {
+	// Token authenticates HTTPS remotes. When empty it is looked up in
+	// REPO_CONCAT_TOKEN (for the hosts listed in REPO_CONCAT_TOKEN_HOST),
+	// GITHUB_TOKEN (github.com only) and then the credentials file.
+	Token string
+	// Username accompanies Token. Defaults to what the host expects for
+	// access tokens.
+	Username string
+	// CredentialsFile holds "host token [username]" lines. Defaults to
+	// DefaultCredentialsFile.
+	CredentialsFile string
+	// SSHKey is a private key for SSH remotes. Without it ssh uses the
+	// agent (SSH_AUTH_SOCK) and the user's ssh configuration.
+	SSHKey string
+}
+
+// DefaultCredentialsFile returns the default location of the credentials file
+func DefaultCredentialsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "repo-concat", "credentials")
+}
+
+// resolve fills in the token and username for a remote from the
+// environment and the credentials file. Tokens are only sent over HTTPS:
+// none is looked up for other remotes, and a token given for a plain
+// http:// remote is refused.
+func (a Auth) resolve(repo *RepoURL) (Auth, error) {
+	host := repo.Host
+	switch repo.scheme() {
+	case "https":
+	case "http":
+		if a.Token != "" {
+			return a, fmt.Errorf("refusing to send a token to %s over plain http", host)
+		}
+		return a, nil
+	default:
+		a.Token = ""
+		return a, nil
+	}
+
+	if a.Token == "" && tokenHostMatches(os.Getenv("REPO_CONCAT_TOKEN_HOST"), host) {
+		a.Token = os.Getenv("REPO_CONCAT_TOKEN")
+	}
+	if a.Token == "" && host == "github.com" {
+		a.Token = os.Getenv("GITHUB_TOKEN")
+	}
+	if a.Token == "" {
+		file := a.CredentialsFile
+		if file == "" {
+			file = DefaultCredentialsFile()
+		}
+		token, username, err := lookupCredentials(file, host)
+		if err != nil {
+			return a, err
+		}
+		a.Token = token
+		if a.Username == "" {
+			a.Username = username
+		}
+	}
+	if a.Token != "" && a.Username == "" {
+		a.Username = defaultTokenUsername(host)
+	}
+	return a, nil
+}
+
+// tokenHostMatches reports whether host is one of the comma separated
+// hosts REPO_CONCAT_TOKEN is scoped to
+func tokenHostMatches(hosts, host string) bool {
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" && strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitArgs returns the global git options installing the credential helper
+func (a Auth) gitArgs() []string {
+	// resolve only keeps tokens for HTTPS remotes
+	if a.Token == "" {
+		return nil
+	}
+	// The empty helper clears any configured helpers so that the token
+	// is not stored by them
+	helper := `!f() { test "$1" = get || return 0; echo "username=$REPO_CONCAT_GIT_USERNAME"; echo "password=$REPO_CONCAT_GIT_TOKEN"; }; f`
+	return []string{"-c", "credential.helper=", "-c", "credential.helper=" + helper}
+}
+
+// gitEnv returns the environment additions for git commands
+func (a Auth) gitEnv() []string {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if a.Token != "" {
+		env = append(env, "REPO_CONCAT_GIT_USERNAME="+a.Username, "REPO_CONCAT_GIT_TOKEN="+a.Token)
+	}
+	if a.SSHKey != "" {
+		key := strings.ReplaceAll(a.SSHKey, "'", `'\''`)
+		env = append(env, "GIT_SSH_COMMAND=ssh -i '"+key+"' -o IdentitiesOnly=yes")
+	}
+	return env
+}
+
+// redact removes the token from s
+func (a Auth) redact(s string) string {
+	if a.Token == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, a.Token, "***")
+}
+
+// lookupCredentials finds the token for host in a credentials file. A
+// missing file is not an error.
+func lookupCredentials(file, host string) (string, string, error) {
+	if file == "" {
+		return "", "", nil
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], host) {
+			continue
+		}
+		if len(fields) > 2 {
+			return fields[1], fields[2], nil
+		}
+		return fields[1], "", nil
+	}
+	return "", "", scanner.Err()
+}
+
+// defaultTokenUsername returns the username hosts expect with access tokens
+func defaultTokenUsername(host string) string {
+	switch {
+	case strings.Contains(host, "gitlab"):
+		return "oauth2"
+	case strings.Contains(host, "bitbucket"):
+		return "x-token-auth"
+	default:
+		return "x-access-token"
+	}
+}
+
+// RedactURL removes any password or token embedded in a URL.
+func RedactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.User == nil {
+		return raw
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.User(parsed.User.Username())
+	} else if parsed.Scheme == "http" || parsed.Scheme == "https" {
+		// A lone user part of an HTTPS URL is usually a token
+		parsed.User = nil
+	}
+	return parsed.String()
+}
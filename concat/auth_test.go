@@ -0,0 +1,117 @@
+package concat
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuthResolve(t *testing.T) {
+	credentials := filepath.Join(t.TempDir(), "credentials")
+	if err := os.WriteFile(credentials, []byte("git.example.com file-token alice\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		url       string
+		token     string
+		env       map[string]string
+		wantToken string
+		wantUser  string
+		wantErr   string
+	}{
+		{
+			name:      "scoped token for its host",
+			url:       "https://git.example.com/org/repo",
+			env:       map[string]string{"REPO_CONCAT_TOKEN": "env-token", "REPO_CONCAT_TOKEN_HOST": "other.example.com, git.example.com"},
+			wantToken: "env-token",
+			wantUser:  "x-access-token",
+		},
+		{
+			name:      "scoped token not for another host",
+			url:       "https://attacker.example.net/org/repo",
+			env:       map[string]string{"REPO_CONCAT_TOKEN": "env-token", "REPO_CONCAT_TOKEN_HOST": "git.example.com"},
+			wantToken: "",
+		},
+		{
+			name:      "unscoped token is ignored",
+			url:       "https://git.example.com/org/repo",
+			env:       map[string]string{"REPO_CONCAT_TOKEN": "env-token"},
+			wantToken: "file-token",
+			wantUser:  "alice",
+		},
+		{
+			name:      "GITHUB_TOKEN on github.com",
+			url:       "https://github.com/org/repo",
+			env:       map[string]string{"GITHUB_TOKEN": "gh-token"},
+			wantToken: "gh-token",
+			wantUser:  "x-access-token",
+		},
+		{
+			name:      "GITHUB_TOKEN not on other hosts",
+			url:       "https://gitlab.com/org/repo",
+			env:       map[string]string{"GITHUB_TOKEN": "gh-token"},
+			wantToken: "",
+		},
+		{
+			name:      "credentials file",
+			url:       "https://git.example.com/org/repo",
+			wantToken: "file-token",
+			wantUser:  "alice",
+		},
+		{
+			name:      "no token looked up for plain http",
+			url:       "http://git.example.com/org/repo",
+			env:       map[string]string{"REPO_CONCAT_TOKEN": "env-token", "REPO_CONCAT_TOKEN_HOST": "git.example.com"},
+			wantToken: "",
+		},
+		{
+			name:    "explicit token refused over plain http",
+			url:     "http://git.example.com/org/repo",
+			token:   "explicit",
+			wantErr: "plain http",
+		},
+		{
+			name:      "no token for ssh",
+			url:       "git@git.example.com:org/repo.git",
+			token:     "explicit",
+			env:       map[string]string{"REPO_CONCAT_TOKEN": "env-token", "REPO_CONCAT_TOKEN_HOST": "git.example.com"},
+			wantToken: "",
+		},
+		{
+			name:      "no token for local repositories",
+			url:       "file:///srv/git/repo.git",
+			token:     "explicit",
+			wantToken: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"REPO_CONCAT_TOKEN", "REPO_CONCAT_TOKEN_HOST", "GITHUB_TOKEN"} {
+				t.Setenv(name, tt.env[name])
+			}
+			repo, err := ParseRepoURL(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth, err := Auth{Token: tt.token, CredentialsFile: credentials}.resolve(repo)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if auth.Token != tt.wantToken || auth.Token != "" && auth.Username != tt.wantUser {
+				t.Errorf("got token %q user %q, want %q %q", auth.Token, auth.Username, tt.wantToken, tt.wantUser)
+			}
+			if (len(auth.gitArgs()) > 0) != (tt.wantToken != "") {
+				t.Errorf("gitArgs() = %q, want a credential helper only with a token", auth.gitArgs())
+			}
+		})
+	}
+}
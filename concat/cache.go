@@ -0,0 +1,126 @@
+package concat
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cacheTTL is how long a cloned repository stays reusable
+const cacheTTL = 5 * time.Minute
+
+// CacheEntry represents cached repository metadata
+type CacheEntry struct {
+	URL       string    `json:"url"`
+	Ref       string    `json:"ref,omitempty"`
+	Commit    string    `json:"commit,omitempty"`
+	Paths     []string  `json:"paths,omitempty"`
+	CachedAt  time.Time `json:"cached_at"`
+	RepoPath  string    `json:"repo_path"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// cacheRoot is where clones and their metadata are cached
+var cacheRoot = filepath.Join("/tmp", "repo-concat-cache")
+
+// getTmpCacheDir returns the cache directory path
+func getTmpCacheDir() string {
+	return cacheRoot
+}
+
+// cacheKey identifies a clone by its URL, ref and any sparse paths
+func cacheKey(parts ...string) string {
+	hash := md5.Sum([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(hash[:])
+}
+
+// getCachedRepo checks if a repository is already cached and valid. It
+// returns nil when there is no usable entry.
+func getCachedRepo(key string) (*CacheEntry, error) {
+	cacheDir := getTmpCacheDir()
+	metadataPath := filepath.Join(cacheDir, key+".json")
+
+	// Check if metadata file exists
+	if _, err := os.Stat(metadataPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	// Read metadata
+	data, err := os.ReadFile(metadataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+
+	// Check if cache is still valid
+	if time.Now().After(entry.ExpiresAt) {
+		// Cache expired, clean up
+		os.Remove(metadataPath)
+		os.RemoveAll(entry.RepoPath)
+		return nil, nil
+	}
+
+	// Check if repo directory still exists
+	if _, err := os.Stat(entry.RepoPath); os.IsNotExist(err) {
+		// Repo directory missing, clean up metadata
+		os.Remove(metadataPath)
+		return nil, nil
+	}
+
+	return &entry, nil
+}
+
+// cacheRepo stores repository information in cache
+func cacheRepo(key string, entry CacheEntry) error {
+	cacheDir := getTmpCacheDir()
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return err
+	}
+
+	metadataPath := filepath.Join(cacheDir, key+".json")
+
+	entry.CachedAt = time.Now()
+	entry.ExpiresAt = entry.CachedAt.Add(cacheTTL)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(metadataPath, data, 0644)
+}
+
+// formatDuration renders a cache age for status messages
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return "just now"
+	}
+	if d < time.Minute {
+		seconds := int(d.Seconds())
+		if seconds == 1 {
+			return "1 second"
+		}
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+	if d < time.Hour {
+		minutes := int(d.Minutes())
+		if minutes == 1 {
+			return "1 minute"
+		}
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	hours := int(d.Hours())
+	if hours == 1 {
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d hours", hours)
+}
@@ -0,0 +1,222 @@
+package concat
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Category is a kind of file that is rarely worth reading and is excluded
+// by default, see Options.Allow.
+type Category string
+
+const (
+	// CategoryLockfile is a dependency lockfile such as package-lock.json
+	// or go.sum, recognised by name.
+	CategoryLockfile Category = "lockfile"
+	// CategoryGenerated is generated code, recognised by a "Code generated
+	// ... DO NOT EDIT." header or a linguist-generated attribute in
+	// .gitattributes.
+	CategoryGenerated Category = "generated"
+	// CategoryMinified is a minified asset or source map, recognised by
+	// name (.min.js, .js.map) or by very long lines.
+	CategoryMinified Category = "minified"
+)
+
+// Categories lists the categories Options.Allow accepts.
+var Categories = []Category{CategoryLockfile, CategoryGenerated, CategoryMinified}
+
+// ParseCategory validates a category name.
+func ParseCategory(name string) (Category, error) {
+	for _, category := range Categories {
+		if string(category) == name {
+			return category, nil
+		}
+	}
+	return "", fmt.Errorf("unknown category %q (expected one of %v)", name, Categories)
+}
+
+// lockfiles are the names package managers give their lockfiles
+var lockfiles = map[string]bool{
+	"package-lock.json":   true,
+	"npm-shrinkwrap.json": true,
+	"yarn.lock":           true,
+	"pnpm-lock.yaml":      true,
+	"bun.lock":            true,
+	"deno.lock":           true,
+	"go.sum":              true,
+	"go.work.sum":         true,
+	"Cargo.lock":          true,
+	"Gemfile.lock":        true,
+	"Pipfile.lock":        true,
+	"poetry.lock":         true,
+	"pdm.lock":            true,
+	"uv.lock":             true,
+	"composer.lock":       true,
+	"mix.lock":            true,
+	"pubspec.lock":        true,
+	"Podfile.lock":        true,
+	"Package.resolved":    true,
+	"flake.lock":          true,
+	"packages.lock.json":  true,
+	"gradle.lockfile":     true,
+}
+
+var (
+	// generatedMarker is the text of the comment marking generated code,
+	// following the Go convention, see isGenerated
+	generatedMarker = regexp.MustCompile(`^Code generated .* DO NOT EDIT`)
+	// minifiedName matches minified assets and source maps by name
+	minifiedName = regexp.MustCompile(`\.min\.(?:js|mjs|cjs|css)$|\.(?:js|mjs|cjs|css)\.map$`)
+	// minifiable matches the files the line length heuristic applies to
+	minifiable = regexp.MustCompile(`\.(?:js|mjs|cjs|css|json)$`)
+)
+
+const (
+	// sniffSize is how much of a file is read to classify its content
+	sniffSize = 4096
+	// minifiedLineLength is the average line length above which a file
+	// looks minified. Hand written code averages well under 100.
+	minifiedLineLength = 200
+)
+
+// classifier recognises the files of each Category a scan does not allow
+type classifier struct {
+	allow map[Category]bool
+	// attributes holds the linguist-generated rules of .gitattributes
+	attributes *gitignore
+}
+
+func newClassifier(fsys fs.FS, allow []Category) *classifier {
+	c := &classifier{allow: make(map[Category]bool)}
+	for _, category := range allow {
+		c.allow[category] = true
+	}
+	if !c.allow[CategoryGenerated] {
+		c.attributes = newGitattributes(fsys)
+	}
+	return c
+}
+
+// byName classifies a file by its path alone
+func (c *classifier) byName(relativePath string) (bool, Reason) {
+	name := path.Base(relativePath)
+	if !c.allow[CategoryLockfile] && lockfiles[name] {
+		return true, Reason{Rule: RuleLockfile}
+	}
+	if !c.allow[CategoryMinified] && minifiedName.MatchString(name) {
+		return true, Reason{Rule: RuleMinified}
+	}
+	if c.attributes != nil {
+		if rule := c.attributes.ignored(relativePath, false); rule != nil {
+			return true, ignoreReason(RuleGenerated, rule)
+		}
+	}
+	return false, Reason{}
+}
+
+// byContent classifies a file by the head of its content. size is the
+// size of the whole file.
+func (c *classifier) byContent(relativePath string, head []byte, size int64) (bool, Reason) {
+	if !c.allow[CategoryGenerated] && isGenerated(head) {
+		return true, Reason{Rule: RuleGenerated}
+	}
+	if !c.allow[CategoryMinified] && minifiable.MatchString(relativePath) && looksMinified(head, size) {
+		return true, Reason{Rule: RuleMinified}
+	}
+	return false, Reason{}
+}
+
+// isGenerated reports whether the comments leading head hold the marker
+// of generated code. As in the Go convention, the marker only counts
+// before the first line that is neither blank nor a comment (//, #, --,
+// or /* */ blocks), so a generator's own source holding the line in a
+// string is not generated.
+func isGenerated(head []byte) bool {
+	inBlock := false
+	for _, line := range bytes.Split(head, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		var text []byte
+		switch {
+		case inBlock:
+			text = bytes.TrimPrefix(line, []byte("*"))
+		case len(line) == 0:
+			continue
+		case bytes.HasPrefix(line, []byte("/*")):
+			text = line[2:]
+			inBlock = true
+		case bytes.HasPrefix(line, []byte("//")), bytes.HasPrefix(line, []byte("--")):
+			text = line[2:]
+		case bytes.HasPrefix(line, []byte("#")):
+			text = line[1:]
+		default:
+			return false
+		}
+		if generatedMarker.Match(bytes.TrimSpace(text)) {
+			return true
+		}
+		if inBlock && bytes.Contains(line, []byte("*/")) {
+			inBlock = false
+		}
+	}
+	return false
+}
+
+// looksMinified reports whether the lines of head are too long to have
+// been written by hand. Files under a kilobyte are never minified.
+func looksMinified(head []byte, size int64) bool {
+	if size < 1024 || len(head) == 0 {
+		return false
+	}
+	lines := bytes.Count(head, []byte("\n"))
+	if head[len(head)-1] != '\n' {
+		lines++
+	}
+	return len(head)/lines > minifiedLineLength
+}
+
+func newGitattributes(fsys fs.FS) *gitignore {
+	g := newGitignore(fsys)
+	g.name, g.info, g.parse = ".gitattributes", ".git/info/attributes", parseGeneratedAttributes
+	return g
+}
+
+// parseGeneratedAttributes parses the lines of the gitattributes file named
+// file that set or unset linguist-generated. Unsetting is kept as a
+// negated rule, so the last matching line wins as in git.
+func parseGeneratedAttributes(data []byte, file string) []ignoreRule {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Negative patterns are not allowed in gitattributes
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "!") {
+			continue
+		}
+		set, found := false, false
+		for _, attribute := range fields[1:] {
+			switch attribute {
+			case "linguist-generated", "linguist-generated=true":
+				set, found = true, true
+			case "-linguist-generated", "!linguist-generated", "linguist-generated=false":
+				set, found = false, true
+			}
+		}
+		if !found {
+			continue
+		}
+		rule, ok := parseIgnoreRule(fields[0])
+		if !ok {
+			continue
+		}
+		rule.negate = !set
+		rule.file = file
+		rule.line = strings.Join(fields, " ")
+		rules = append(rules, rule)
+	}
+	return rules
+}
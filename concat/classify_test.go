@@ -0,0 +1,32 @@
+package concat
+
+import "testing"
+
+func TestIsGenerated(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want bool
+	}{
+		{"go", "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage pb\n", true},
+		{"after a license and build tag", "// Copyright 2024 Acme\n\n//go:build linux\n\n// Code generated by stringer; DO NOT EDIT.\npackage x\n", true},
+		{"hash comment", "#!/bin/sh\n# Code generated by make gen. DO NOT EDIT.\necho hi\n", true},
+		{"sql comment", "-- Code generated by sqlc. DO NOT EDIT.\nSELECT 1;\n", true},
+		{"block comment", "/*\n * Copyright Acme\n *\n * Code generated by openapi. DO NOT EDIT.\n */\nexport {}\n", true},
+		{"one line block comment", "/* Code generated by tool. DO NOT EDIT. */\nvar x;\n", true},
+		{"indented", "\n   // Code generated by x. DO NOT EDIT.\n", true},
+		{"after code", "package gen\n\n// Code generated by x. DO NOT EDIT.\n", false},
+		{"in a raw string", "package gen\n\nconst header = `\n// Code generated by mygen. DO NOT EDIT.\n`\n", false},
+		{"after a block comment", "/* license */\nint x;\n// Code generated by x. DO NOT EDIT.\n", false},
+		{"marker without DO NOT EDIT", "// Code generated by x.\npackage y\n", false},
+		{"not at the start of the comment", "// Note: Code generated by x. DO NOT EDIT.\n", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isGenerated([]byte(tt.head)); got != tt.want {
+				t.Errorf("isGenerated(%q) = %v, want %v", tt.head, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+// Package concat is the repository concatenation engine shared by the command
+// line and TUI front ends.
+//
+// A run is a pipeline of four stages: a Source resolves the input to a Tree,
+// a Walker visits every file in the tree, a Matcher decides which files are
+// kept and a Renderer writes the kept files to an io.Writer. Front ends that
+// use the same Options get byte-identical output.
+package concat
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// File describes a single file of a Tree.
+type File struct {
+	// Path is the slash separated path relative to the tree root.
+	Path    string
+	Size    int64
+	ModTime time.Time
+
+	// Source indexes Document.Sources. It is set when a document is
+	// written and is zero for single-source documents.
+	Source int
+
+	// Oversize is set by Scan on files beyond the size limits that are
+	// still included, and Limit is the number of bytes kept when they are
+	// truncated.
+	Oversize SizePolicy
+	Limit    int64
+
+	// Encoding is set by Scan on text files that are not UTF-8 and names
+	// the encoding their content is transcoded from, such as "UTF-16LE" or
+	// "Windows-1252".
+	Encoding string
+}
+
+// Options controls file selection and rendering.
+type Options struct {
+	// Include and Exclude are regex, glob or path (/dir) patterns.
+	Include []string
+	Exclude []string
+
+	// NoGitignore disables .gitignore, .git/info/exclude and nested
+	// .gitignore rules, which are honored by default.
+	NoGitignore bool
+
+	// NoProjectConfig disables .repoconcat.yaml and .repoconcatignore at
+	// the root of each source, see ProjectConfig.
+	NoProjectConfig bool
+
+	// Allow lists the Categories that are kept. Lockfiles, generated
+	// code and minified files are excluded by default.
+	Allow []Category
+
+	// Order is the file order, one of Orders. Defaults to "path".
+	Order string
+
+	// MaxTokens is the estimated token budget of a source. Files that
+	// would exceed it are excluded, in order. Zero means no budget.
+	MaxTokens int
+
+	// MaxFileSize and MaxTotalSize limit the bytes of a single file and of
+	// all files of a source. Files beyond them are handled by SizePolicy,
+	// which defaults to SkipOversize. Zero means no limit.
+	MaxFileSize  int64
+	MaxTotalSize int64
+	SizePolicy   SizePolicy
+
+	// Renderer formats the output. Defaults to MarkdownRenderer.
+	Renderer Renderer
+
+	// Tree prepends a directory tree of the selected files, and TOC a
+	// table of contents with the line and estimated tokens of each file.
+	// The XML and JSON formats only have the tree.
+	Tree bool
+	TOC  bool
+
+	// Parallelism is how many files are classified or read at once.
+	// Defaults to the number of CPUs.
+	Parallelism int
+
+	// ReadAhead bounds the bytes of file content read ahead of the
+	// renderer, or of the token budget, by the parallel readers. A larger
+	// file is read on its own. Defaults to DefaultReadAhead.
+	ReadAhead int64
+
+	// Progress receives an event each time a file has been classified or
+	// read. Events come in the order workers finish, one at a time. It may
+	// be nil.
+	Progress func(ProgressEvent)
+
+	// Generated is the timestamp written to the output header.
+	// Defaults to the time Write is called.
+	Generated time.Time
+
+	// Notify receives status messages such as "success" or "warning".
+	// It may be nil.
+	Notify func(level, message string)
+}
+
+func (o Options) notify(level, message string) {
+	if o.Notify != nil {
+		o.Notify(level, message)
+	}
+}
+
+// Write renders files from tree to w.
+func Write(w io.Writer, tree *Tree, files []File, opts Options) error {
+	return WriteParts(w, []Part{{Tree: tree, Files: files}}, opts)
+}
+
+// Part is one source's share of a document.
+type Part struct {
+	Tree  *Tree
+	Files []File
+}
+
+// WriteParts renders several sources into one document. When there is
+// more than one part, every source is labelled (see LabelTrees) and file
+// paths are shown prefixed with their source's label.
+func WriteParts(w io.Writer, parts []Part, opts Options) error {
+	renderer := opts.Renderer
+	if renderer == nil {
+		renderer = MarkdownRenderer{}
+	}
+
+	generated := opts.Generated
+	if generated.IsZero() {
+		generated = time.Now()
+	}
+
+	trees := make([]*Tree, len(parts))
+	for i, part := range parts {
+		trees[i] = part.Tree
+	}
+	if len(trees) > 1 {
+		LabelTrees(trees)
+	}
+
+	doc := &Document{
+		Generated: generated,
+		opts:      opts,
+	}
+	for i, part := range parts {
+		doc.Sources = append(doc.Sources, part.Tree.SourceInfo)
+		doc.fs = append(doc.fs, part.Tree.FS)
+		for _, file := range part.Files {
+			file.Source = i
+			doc.Files = append(doc.Files, file)
+		}
+	}
+	if len(doc.Sources) == 1 {
+		doc.SourceInfo = doc.Sources[0]
+	}
+
+	return renderer.Render(w, doc)
+}
+
+// LabelTrees gives every tree without a label a unique one derived from
+// its name, such as "api", "sdk" and "sdk-2".
+func LabelTrees(trees []*Tree) {
+	used := make(map[string]bool)
+	for _, tree := range trees {
+		if tree.Label != "" {
+			used[tree.Label] = true
+		}
+	}
+	for _, tree := range trees {
+		if tree.Label != "" {
+			continue
+		}
+		label := tree.Name
+		for n := 2; used[label]; n++ {
+			label = fmt.Sprintf("%s-%d", tree.Name, n)
+		}
+		used[label] = true
+		tree.Label = label
+	}
+}
+
+// OutputFileName returns the timestamped output file name for a tree name.
+func OutputFileName(name string, t time.Time) string {
+	return fmt.Sprintf("%s-concat-%s.txt", name, t.Format("20060102-150405"))
+}
+
+// EstimateTokens gives a rough token count for content.
+func EstimateTokens(content string) int {
+	words := strings.Fields(content)
+	return len(words) * 4 / 3
+}
+
+// TokenCounter is an io.Writer that estimates the tokens of everything
+// written to it, as EstimateTokens would for the whole stream, without
+// keeping it.
+type TokenCounter struct {
+	words  int
+	inWord bool
+	// partial holds a rune cut off at the end of the last write
+	partial []byte
+}
+
+// Write implements io.Writer.
+func (c *TokenCounter) Write(p []byte) (int, error) {
+	buf := p
+	if len(c.partial) > 0 {
+		buf = append(c.partial, p...)
+		c.partial = nil
+	}
+	for len(buf) > 0 {
+		if !utf8.FullRune(buf) {
+			c.partial = append([]byte(nil), buf...)
+			break
+		}
+		r, size := utf8.DecodeRune(buf)
+		space := unicode.IsSpace(r)
+		if !space && !c.inWord {
+			c.words++
+		}
+		c.inWord = !space
+		buf = buf[size:]
+	}
+	return len(p), nil
+}
+
+// Tokens returns the estimate for what has been written so far.
+func (c *TokenCounter) Tokens() int {
+	words := c.words
+	if len(c.partial) > 0 && !c.inWord {
+		words++
+	}
+	return words * 4 / 3
+}
@@ -0,0 +1,159 @@
+package concat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Project configuration files looked up at the root of a source
+const (
+	ConfigFileName = ".repoconcat.yaml"
+	IgnoreFileName = ".repoconcatignore"
+)
+
+// Orders lists the file orderings Options.Order accepts.
+var Orders = []string{"path", "size", "modified"}
+
+// ProjectConfig is the configuration a source carries in .repoconcat.yaml
+// and .repoconcatignore, so a team can share its filters.
+type ProjectConfig struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	// Format is the output format, see NewRenderer.
+	Format string `yaml:"format"`
+	// MaxTokens is the token budget, see Options.MaxTokens.
+	MaxTokens int `yaml:"max_tokens"`
+	// Order is one of Orders.
+	Order string `yaml:"order"`
+	// MaxFileSize and MaxTotalSize are sizes such as "1MB", see ParseSize,
+	// and Oversize is the SizePolicy for files beyond them.
+	MaxFileSize  string `yaml:"max_file_size"`
+	MaxTotalSize string `yaml:"max_total_size"`
+	Oversize     string `yaml:"oversize"`
+	// Allow lists the Categories to keep, see Options.Allow.
+	Allow []Category `yaml:"allow"`
+	// Tree and TOC start the output with a directory tree and a table of
+	// contents, see Options.Tree. Like Format they apply to the whole
+	// document, so front ends resolve them across sources.
+	Tree bool `yaml:"tree"`
+	TOC  bool `yaml:"toc"`
+
+	// Files lists the configuration files that were found.
+	Files []string `yaml:"-"`
+
+	ignore []ignoreRule
+}
+
+// LoadProjectConfig reads .repoconcat.yaml and .repoconcatignore from the
+// root of fsys. Missing files leave the config empty.
+func LoadProjectConfig(fsys fs.FS) (*ProjectConfig, error) {
+	config := &ProjectConfig{}
+
+	data, err := fs.ReadFile(fsys, ConfigFileName)
+	if err == nil {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("invalid %s: %w", ConfigFileName, err)
+		}
+		if err := config.validate(); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", ConfigFileName, err)
+		}
+		config.Files = append(config.Files, ConfigFileName)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", ConfigFileName, err)
+	}
+
+	data, err = fs.ReadFile(fsys, IgnoreFileName)
+	if err == nil {
+		config.ignore = parseIgnoreRules(data, IgnoreFileName)
+		config.Files = append(config.Files, IgnoreFileName)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", IgnoreFileName, err)
+	}
+
+	return config, nil
+}
+
+func (c *ProjectConfig) validate() error {
+	if c.Format != "" {
+		if _, err := NewRenderer(c.Format); err != nil {
+			return err
+		}
+	}
+	if c.Order != "" && !validOrder(c.Order) {
+		return fmt.Errorf("unknown order %q (expected one of %v)", c.Order, Orders)
+	}
+	if c.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative")
+	}
+	if _, err := ParseSize(c.MaxFileSize); err != nil {
+		return fmt.Errorf("max_file_size: %w", err)
+	}
+	if _, err := ParseSize(c.MaxTotalSize); err != nil {
+		return fmt.Errorf("max_total_size: %w", err)
+	}
+	if _, err := ParseSizePolicy(c.Oversize); err != nil {
+		return err
+	}
+	for _, category := range c.Allow {
+		if _, err := ParseCategory(string(category)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge applies the project config underneath opts. Command line values
+// win: exclusions and allowed categories are combined, inclusions given in
+// opts replace those of the config and set scalars take precedence.
+func (c *ProjectConfig) merge(opts Options) Options {
+	if len(opts.Include) == 0 {
+		opts.Include = c.Include
+	}
+	opts.Exclude = append(append([]string{}, c.Exclude...), opts.Exclude...)
+	opts.Allow = append(append([]Category{}, c.Allow...), opts.Allow...)
+	if opts.Order == "" {
+		opts.Order = c.Order
+	}
+	if opts.MaxTokens == 0 {
+		opts.MaxTokens = c.MaxTokens
+	}
+	// Sizes were checked by validate
+	if opts.MaxFileSize == 0 {
+		opts.MaxFileSize, _ = ParseSize(c.MaxFileSize)
+	}
+	if opts.MaxTotalSize == 0 {
+		opts.MaxTotalSize, _ = ParseSize(c.MaxTotalSize)
+	}
+	if opts.SizePolicy == "" {
+		opts.SizePolicy = SizePolicy(c.Oversize)
+	}
+	return opts
+}
+
+func validOrder(order string) bool {
+	for _, o := range Orders {
+		if o == order {
+			return true
+		}
+	}
+	return false
+}
+
+// sortFiles orders files by path, by size (smallest first) or by
+// modification time (newest first). Ties keep path order.
+func sortFiles(files []File, order string) {
+	switch order {
+	case "size":
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Size < files[j].Size })
+	case "modified":
+		sort.SliceStable(files, func(i, j int) bool { return files[i].ModTime.After(files[j].ModTime) })
+	}
+}
@@ -0,0 +1,176 @@
+package concat
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+)
+
+// encodings are the encodings text files are transcoded from, by the
+// name recorded in File.Encoding
+var encodings = map[string]encoding.Encoding{
+	"UTF-16LE":     unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	"UTF-16BE":     unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	"UTF-32LE":     utf32.UTF32(utf32.LittleEndian, utf32.UseBOM),
+	"UTF-32BE":     utf32.UTF32(utf32.BigEndian, utf32.UseBOM),
+	"Windows-1252": charmap.Windows1252,
+	"ISO-8859-1":   charmap.ISO8859_1,
+	"Shift_JIS":    japanese.ShiftJIS,
+	"GB18030":      simplifiedchinese.GB18030,
+}
+
+// byteOrderMarks are checked in order, as the UTF-32LE mark starts with
+// the UTF-16LE one
+var byteOrderMarks = []struct {
+	mark     []byte
+	encoding string
+}{
+	{[]byte{0xFF, 0xFE, 0x00, 0x00}, "UTF-32LE"},
+	{[]byte{0x00, 0x00, 0xFE, 0xFF}, "UTF-32BE"},
+	{utf8BOM, ""},
+	{[]byte{0xFF, 0xFE}, "UTF-16LE"},
+	{[]byte{0xFE, 0xFF}, "UTF-16BE"},
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// detectEncoding decides from the head of a file whether it is text and
+// in which encoding. The encoding is empty for UTF-8. In order it looks
+// for a byte order mark, BOM-less UTF-16 and UTF-32, binary content, valid
+// UTF-8 and finally the legacy encodings.
+func detectEncoding(head []byte) (string, bool) {
+	for _, bom := range byteOrderMarks {
+		if bytes.HasPrefix(head, bom.mark) {
+			return bom.encoding, true
+		}
+	}
+	if name := detectWideUnicode(head); name != "" {
+		return name, true
+	}
+	if bytes.IndexByte(head, 0) >= 0 || hasControlBytes(head) {
+		return "", false
+	}
+	if validUTF8(head) {
+		return "", true
+	}
+	return detectLegacy(head), true
+}
+
+// detectWideUnicode recognises UTF-16 and UTF-32 without a byte order mark
+// by where the zero bytes of mostly ASCII text fall
+func detectWideUnicode(head []byte) string {
+	if len(head) >= 16 {
+		z := zeroRatios(head, 4)
+		switch {
+		case z[0] < 0.1 && z[1] > 0.9 && z[2] > 0.9 && z[3] > 0.9:
+			return "UTF-32LE"
+		case z[3] < 0.1 && z[0] > 0.9 && z[1] > 0.9 && z[2] > 0.9:
+			return "UTF-32BE"
+		}
+	}
+	if len(head) >= 8 {
+		z := zeroRatios(head, 2)
+		switch {
+		case z[0] < 0.1 && z[1] > 0.7:
+			return "UTF-16LE"
+		case z[1] < 0.1 && z[0] > 0.7:
+			return "UTF-16BE"
+		}
+	}
+	return ""
+}
+
+// zeroRatios returns, for each byte position within units of width bytes,
+// the share of units with a zero byte there
+func zeroRatios(head []byte, width int) []float64 {
+	units := len(head) / width
+	ratios := make([]float64, width)
+	for i := 0; i < units*width; i++ {
+		if head[i] == 0 {
+			ratios[i%width]++
+		}
+	}
+	for i := range ratios {
+		ratios[i] /= float64(units)
+	}
+	return ratios
+}
+
+// hasControlBytes reports whether more than one byte in twenty is a
+// control character that text does not use, as in binary formats whose
+// header happens to be free of NUL bytes
+func hasControlBytes(head []byte) bool {
+	control := 0
+	for _, c := range head {
+		if c < 0x20 && !bytes.ContainsRune([]byte("\t\n\v\f\r\b\x1b"), rune(c)) || c == 0x7F {
+			control++
+		}
+	}
+	return control*20 > len(head)
+}
+
+// validUTF8 is utf8.Valid, allowing a rune cut off at the end of the head
+func validUTF8(head []byte) bool {
+	if utf8.Valid(head) {
+		return true
+	}
+	for i := 1; i < utf8.UTFMax && i <= len(head); i++ {
+		if tail := head[len(head)-i:]; utf8.RuneStart(tail[0]) {
+			return !utf8.FullRune(tail) && utf8.Valid(head[:len(head)-i])
+		}
+	}
+	return false
+}
+
+// detectLegacy picks the legacy encoding of text that is not UTF-8. Text
+// whose non-ASCII bytes mostly come in pairs is tried as Shift_JIS and then
+// GB18030; other text is Windows-1252, or ISO-8859-1 when it uses bytes
+// Windows-1252 leaves undefined.
+func detectLegacy(head []byte) string {
+	high, paired := 0, 0
+	for i, c := range head {
+		if c < 0x80 {
+			continue
+		}
+		high++
+		if i > 0 && head[i-1] >= 0x80 || i+1 < len(head) && head[i+1] >= 0x80 {
+			paired++
+		}
+	}
+	candidates := []string{"Windows-1252"}
+	if paired*2 > high {
+		candidates = []string{"Shift_JIS", "GB18030", "Windows-1252"}
+	}
+	for _, name := range candidates {
+		if decodesCleanly(encodings[name], head) {
+			return name
+		}
+	}
+	return "ISO-8859-1"
+}
+
+// decodesCleanly reports whether head decodes without replacement
+// characters, but for one at the very end where a character may be cut off
+func decodesCleanly(enc encoding.Encoding, head []byte) bool {
+	decoded, err := enc.NewDecoder().Bytes(head)
+	if err != nil {
+		return false
+	}
+	i := bytes.IndexRune(decoded, utf8.RuneError)
+	return i < 0 || i == len(decoded)-len(string(utf8.RuneError))
+}
+
+// transcode converts content from the named encoding to UTF-8 and drops a
+// UTF-8 byte order mark
+func transcode(content []byte, name string) ([]byte, error) {
+	if name == "" {
+		return bytes.TrimPrefix(content, utf8BOM), nil
+	}
+	return encodings[name].NewDecoder().Bytes(content)
+}
@@ -0,0 +1,96 @@
+package concat
+
+import (
+	"bytes"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+)
+
+// encode encodes text with enc, failing the test on characters enc lacks
+func encode(t *testing.T, enc encoding.Encoding, text string) []byte {
+	t.Helper()
+	encoded, err := enc.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestDetectEncoding(t *testing.T) {
+	const text = "package main\n\n// Hello, world\nfunc main() {}\n"
+	utf16LE := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	utf16BE := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	utf32LE := utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM)
+	utf32BE := utf32.UTF32(utf32.BigEndian, utf32.IgnoreBOM)
+
+	tests := []struct {
+		name     string
+		head     []byte
+		encoding string
+		text     bool
+	}{
+		{"ASCII", []byte(text), "", true},
+		{"UTF-8", []byte("// café résumé\n"), "", true},
+		{"UTF-8 BOM", append([]byte{0xEF, 0xBB, 0xBF}, text...), "", true},
+		{"UTF-8 rune cut off at the end", []byte("// café \xe2\x82"), "", true},
+		{"UTF-16LE BOM", append([]byte{0xFF, 0xFE}, encode(t, utf16LE, text)...), "UTF-16LE", true},
+		{"UTF-16BE BOM", append([]byte{0xFE, 0xFF}, encode(t, utf16BE, text)...), "UTF-16BE", true},
+		{"UTF-16LE", encode(t, utf16LE, text), "UTF-16LE", true},
+		{"UTF-16BE", encode(t, utf16BE, text), "UTF-16BE", true},
+		{"UTF-32LE BOM", append([]byte{0xFF, 0xFE, 0x00, 0x00}, encode(t, utf32LE, text)...), "UTF-32LE", true},
+		{"UTF-32BE BOM", append([]byte{0x00, 0x00, 0xFE, 0xFF}, encode(t, utf32BE, text)...), "UTF-32BE", true},
+		{"UTF-32LE", encode(t, utf32LE, text), "UTF-32LE", true},
+		{"UTF-32BE", encode(t, utf32BE, text), "UTF-32BE", true},
+		{"Latin-1", []byte("// caf\xe9 r\xe9sum\xe9 na\xefve\n"), "Windows-1252", true},
+		{"Latin-1 outside Windows-1252", []byte("// caf\xe9 \x81\n"), "ISO-8859-1", true},
+		{"Shift_JIS", encode(t, japanese.ShiftJIS, "// こんにちは、世界\n"), "Shift_JIS", true},
+		{"binary with NUL bytes", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "", false},
+		{"binary without NUL bytes", bytes.Repeat([]byte{0x01, 0x02, 'a', 0x03, 0x7F, 0x04, 'b', 0x05}, 64), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, text := detectEncoding(tt.head)
+			if encoding != tt.encoding || text != tt.text {
+				t.Errorf("detectEncoding() = %q, %v; want %q, %v", encoding, text, tt.encoding, tt.text)
+			}
+		})
+	}
+}
+
+// TestLegacyEncodingAfterHead checks a file that only leaves ASCII after
+// the head Scan sniffs
+func TestLegacyEncodingAfterHead(t *testing.T) {
+	content := strings.Repeat("// plain ASCII comment line\n", 2*sniffSize/28) + "// caf\xe9 r\xe9sum\xe9\n"
+	fsys := fstest.MapFS{"main.go": &fstest.MapFile{Data: []byte(content)}}
+
+	file := File{Path: "main.go", Size: int64(len(content))}
+	got, err := readFile(fsys, &file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Encoding != "Windows-1252" {
+		t.Errorf("Encoding = %q, want Windows-1252", file.Encoding)
+	}
+	if !bytes.HasSuffix(got, []byte("// café résumé\n")) {
+		t.Errorf("content ends in %q", got[len(got)-20:])
+	}
+
+	var files []File
+	err = readFiles([]File{{Path: "main.go", Size: int64(len(content))}}, func(File) fs.FS { return fsys }, Options{}, StageRead, func(file File, content []byte, err error) error {
+		files = append(files, file)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0].Encoding != "Windows-1252" {
+		t.Errorf("readFiles passed Encoding %q, want Windows-1252", files[0].Encoding)
+	}
+}
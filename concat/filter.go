@@ -0,0 +1,430 @@
+package concat
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DefaultExclusions are applied on top of the user's exclude patterns.
+var DefaultExclusions = []string{
+	`\.git/`,
+	`\.gitignore$`,
+	`\.repoconcatignore$`,
+	`\.repoconcat\.yaml$`,
+	`\.DS_Store$`,
+	`node_modules/`,
+	`\.env$`,
+	`\.(jpg|jpeg|png|gif|svg|ico|bmp|tiff|webp)$`,
+	`\.(mp4|mov|avi|mkv|webm|flv)$`,
+	`\.(mp3|wav|flac|aac|ogg)$`,
+	`\.(zip|tar|gz|rar|7z|exe|dmg|pkg)$`,
+	`\.(pdf|doc|docx|xls|xlsx|ppt|pptx)$`,
+}
+
+// PatternKind is the syntax of a filter pattern.
+type PatternKind int
+
+const (
+	// RegexPattern is a regular expression matched against the relative
+	// path or the base name. Written with a "regex:" prefix, or detected
+	// when a pattern is neither a path pattern nor a glob.
+	RegexPattern PatternKind = iota
+	// GlobPattern is a shell glob such as *.go or src/**/*.{ts,tsx},
+	// written with a "glob:" prefix or detected by isGlobPattern. A glob
+	// without a slash matches the base name, otherwise the whole path
+	// from the root.
+	GlobPattern
+	// PathPattern starts with / and matches a file or directory from the
+	// root, or only a directory when it ends with /, see pathPattern.
+	PathPattern
+	// GitignorePattern is a single gitignore line, written with a
+	// "gitignore:" prefix.
+	GitignorePattern
+)
+
+func (k PatternKind) String() string {
+	switch k {
+	case GlobPattern:
+		return "glob"
+	case PathPattern:
+		return "path"
+	case GitignorePattern:
+		return "gitignore"
+	default:
+		return "regex"
+	}
+}
+
+// Pattern is a compiled include or exclude pattern.
+type Pattern struct {
+	// Source is the pattern as written.
+	Source string
+	Kind   PatternKind
+
+	regex  *regexp.Regexp
+	ignore ignoreRule
+	// covers is set for regexes that match every path starting with
+	// anything they match, see coversDir
+	covers bool
+	// base is set for globs matched against the base name only
+	base bool
+	// dirs matches the directories a glob ending in /** covers
+	dirs *regexp.Regexp
+	path pathPattern
+}
+
+// CompilePattern detects the kind of pattern and compiles it. The rule is:
+// a "regex:", "glob:" or "gitignore:" prefix selects that syntax, a leading
+// slash without wildcards makes a path pattern, a * without regex-only
+// constructs (see isGlobPattern) makes a glob and anything else is a regex.
+func CompilePattern(pattern string) (*Pattern, error) {
+	p := &Pattern{Source: pattern}
+	body := pattern
+	switch {
+	case strings.HasPrefix(pattern, "regex:"):
+		p.Kind = RegexPattern
+		body = strings.TrimPrefix(pattern, "regex:")
+
+	case strings.HasPrefix(pattern, "glob:"):
+		p.Kind = GlobPattern
+		body = strings.TrimPrefix(pattern, "glob:")
+
+	case strings.HasPrefix(pattern, "gitignore:"):
+		p.Kind = GitignorePattern
+		rule, ok := parseIgnoreRule(strings.TrimPrefix(pattern, "gitignore:"))
+		if !ok {
+			return nil, fmt.Errorf("empty gitignore rule")
+		}
+		if rule.negate {
+			return nil, fmt.Errorf("negated gitignore rules are not supported, use -include")
+		}
+		p.ignore = rule
+		return p, nil
+
+	case isPathPattern(pattern):
+		p.Kind = PathPattern
+		p.path = parsePathPattern(pattern)
+		return p, nil
+
+	case isGlobPattern(pattern):
+		p.Kind = GlobPattern
+	}
+
+	if p.Kind == GlobPattern {
+		if err := p.compileGlob(body); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	regex, err := regexp.Compile(body)
+	if err != nil {
+		return nil, err
+	}
+	p.regex = regex
+	// A regex that matches "dir/" also matches anything starting with it,
+	// unless it is anchored to the end of the path or a word boundary
+	p.covers = !strings.Contains(body, "$") && !strings.Contains(body, `\z`) && !strings.Contains(body, `\b`) && !strings.Contains(body, `\B`)
+	return p, nil
+}
+
+func (p *Pattern) compileGlob(glob string) error {
+	// Globs with a slash are anchored at the root anyway
+	p.base = !strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+
+	expr, err := globToRegex(glob)
+	if err != nil {
+		return err
+	}
+	if p.regex, err = regexp.Compile(expr); err != nil {
+		return err
+	}
+
+	// A glob ending in /** covers every directory its prefix matches
+	if prefix, ok := strings.CutSuffix(glob, "/**"); ok && prefix != "" {
+		expr, err := globToRegex(prefix)
+		if err != nil {
+			return err
+		}
+		if p.dirs, err = regexp.Compile(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match reports whether the pattern matches the slash separated relative
+// path of a file.
+func (p *Pattern) Match(relativePath string) bool {
+	switch p.Kind {
+	case PathPattern:
+		return p.path.match(relativePath)
+	case GitignorePattern:
+		return p.matchIgnore(relativePath)
+	}
+	baseName := relativePath[strings.LastIndex(relativePath, "/")+1:]
+	if p.Kind == GlobPattern {
+		if p.base {
+			return p.regex.MatchString(baseName)
+		}
+		return p.regex.MatchString(relativePath)
+	}
+	return p.regex.MatchString(relativePath) || p.regex.MatchString(baseName)
+}
+
+// matchIgnore applies a gitignore rule to a file and its parent directories
+func (p *Pattern) matchIgnore(relativePath string) bool {
+	if !p.ignore.dirOnly && p.ignore.regex.MatchString(relativePath) {
+		return true
+	}
+	if i := strings.LastIndex(relativePath, "/"); i >= 0 {
+		return p.matchIgnoreDir(relativePath[:i])
+	}
+	return false
+}
+
+// matchIgnoreDir applies a gitignore rule to a directory and its parents
+func (p *Pattern) matchIgnoreDir(dir string) bool {
+	for {
+		if p.ignore.regex.MatchString(dir) {
+			return true
+		}
+		i := strings.LastIndex(dir, "/")
+		if i < 0 {
+			return false
+		}
+		dir = dir[:i]
+	}
+}
+
+// coversDir reports whether the pattern matches every path below dir
+func (p *Pattern) coversDir(dir string) bool {
+	switch p.Kind {
+	case PathPattern:
+		return p.path.match(dir + "/")
+	case GitignorePattern:
+		return p.matchIgnoreDir(dir)
+	case GlobPattern:
+		if p.dirs == nil {
+			return false
+		}
+		for {
+			if p.dirs.MatchString(dir) {
+				return true
+			}
+			i := strings.LastIndex(dir, "/")
+			if i < 0 {
+				return false
+			}
+			dir = dir[:i]
+		}
+	}
+	return p.covers && p.regex.MatchString(dir+"/")
+}
+
+// Rule names what decided whether a path is selected.
+type Rule string
+
+const (
+	// RuleIncluded means the path matched an include pattern, or there
+	// are none.
+	RuleIncluded Rule = "included"
+	// RuleExcluded means the path matched an exclude pattern.
+	RuleExcluded Rule = "excluded"
+	// RuleDefault means the path matched one of DefaultExclusions.
+	RuleDefault Rule = "default exclusion"
+	// RuleNotIncluded means include patterns were given and none matched.
+	RuleNotIncluded Rule = "not included"
+	// RuleGitignore means git ignores the path.
+	RuleGitignore Rule = "ignored by git"
+	// RuleIgnoreFile means .repoconcatignore ignores the path.
+	RuleIgnoreFile Rule = "ignored by project"
+	// RuleBinary means the content looks binary.
+	RuleBinary Rule = "binary"
+	// RuleLockfile, RuleGenerated and RuleMinified mean the file belongs
+	// to a Category that is not allowed.
+	RuleLockfile  Rule = "lockfile"
+	RuleGenerated Rule = "generated code"
+	RuleMinified  Rule = "minified"
+	// RuleTokenBudget means the file did not fit the token budget.
+	RuleTokenBudget Rule = "over token budget"
+	// RuleFileSize means the file is larger than the per-file size limit.
+	RuleFileSize Rule = "over max file size"
+	// RuleTotalSize means the file did not fit the total size limit.
+	RuleTotalSize Rule = "over max total size"
+	// RuleTruncated means the file is included, truncated to the size
+	// limits.
+	RuleTruncated Rule = "truncated to size limit"
+	// RuleStubbed means the file is included as a stub, being over the
+	// size limits.
+	RuleStubbed Rule = "stubbed, over size limit"
+	// RuleOutOfScope means the path lies outside the directory or file a
+	// browser URL points to.
+	RuleOutOfScope Rule = "outside the URL's path"
+)
+
+// Reason explains a selection decision.
+type Reason struct {
+	Rule Rule
+	// Pattern is the pattern that decided, if any.
+	Pattern *Pattern
+	// File is the ignore file holding Pattern, for ignore file rules.
+	File string
+}
+
+func (r Reason) String() string {
+	switch {
+	case r.Pattern == nil:
+		return string(r.Rule)
+	case r.File != "":
+		return fmt.Sprintf("%s (%s: %s)", r.Rule, r.File, r.Pattern.Source)
+	default:
+		return fmt.Sprintf("%s by %s pattern '%s'", r.Rule, r.Pattern.Kind, r.Pattern.Source)
+	}
+}
+
+// ignoreReason explains a decision made by an ignore file rule
+func ignoreReason(rule Rule, ignore *ignoreRule) Reason {
+	return Reason{
+		Rule:    rule,
+		Pattern: &Pattern{Source: ignore.line, Kind: GitignorePattern, ignore: *ignore},
+		File:    ignore.file,
+	}
+}
+
+// Matcher decides whether a path is selected by include and exclude
+// patterns. Patterns are compiled once, so a Matcher is cheap to apply to
+// every file of a large tree.
+type Matcher struct {
+	include  []*Pattern
+	exclude  []*Pattern
+	defaults []*Pattern
+}
+
+// NewMatcher compiles the patterns and returns a Matcher. The default
+// exclusions are always applied after exclude.
+func NewMatcher(include, exclude []string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, pattern := range exclude {
+		p, err := CompilePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclusion pattern '%s': %w", pattern, err)
+		}
+		m.exclude = append(m.exclude, p)
+	}
+	for _, pattern := range include {
+		p, err := CompilePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inclusion pattern '%s': %w", pattern, err)
+		}
+		m.include = append(m.include, p)
+	}
+	for _, pattern := range DefaultExclusions {
+		p, err := CompilePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default exclusion '%s': %w", pattern, err)
+		}
+		m.defaults = append(m.defaults, p)
+	}
+	return m, nil
+}
+
+// Match reports whether the slash separated relative path is selected,
+// and why.
+func (m *Matcher) Match(relativePath string) (bool, Reason) {
+	for _, p := range m.exclude {
+		if p.Match(relativePath) {
+			return false, Reason{Rule: RuleExcluded, Pattern: p}
+		}
+	}
+	for _, p := range m.defaults {
+		if p.Match(relativePath) {
+			return false, Reason{Rule: RuleDefault, Pattern: p}
+		}
+	}
+
+	// If inclusions are specified, file must match at least one of them
+	if len(m.include) == 0 {
+		return true, Reason{Rule: RuleIncluded}
+	}
+	for _, p := range m.include {
+		if p.Match(relativePath) {
+			return true, Reason{Rule: RuleIncluded, Pattern: p}
+		}
+	}
+	return false, Reason{Rule: RuleNotIncluded}
+}
+
+// SkipDir reports whether no file below the directory at the slash
+// separated relative path can be selected, so the directory need not be
+// walked, and why. It only answers true when that is certain: an exclude
+// pattern matches every path below the directory, or every include
+// pattern is a path pattern pointing elsewhere.
+func (m *Matcher) SkipDir(dir string) (bool, Reason) {
+	for _, p := range m.exclude {
+		if p.coversDir(dir) {
+			return true, Reason{Rule: RuleExcluded, Pattern: p}
+		}
+	}
+	for _, p := range m.defaults {
+		if p.coversDir(dir) {
+			return true, Reason{Rule: RuleDefault, Pattern: p}
+		}
+	}
+
+	if len(m.include) == 0 {
+		return false, Reason{Rule: RuleIncluded}
+	}
+	for _, p := range m.include {
+		if p.Kind != PathPattern || p.path.reaches(dir) {
+			return false, Reason{Rule: RuleIncluded}
+		}
+	}
+	return true, Reason{Rule: RuleNotIncluded}
+}
+
+// isPathPattern determines if a pattern is a path-based pattern. A leading
+// slash with glob wildcards makes a root-anchored glob instead.
+func isPathPattern(pattern string) bool {
+	return strings.HasPrefix(pattern, "/") && !strings.ContainsAny(pattern, "*?[{")
+}
+
+// pathPattern is a parsed path pattern: a path from the root that matches
+// that exact file or anything below it as a directory (/util matches util
+// and util/x.go, but not utils/ or util.go), or only a directory when
+// written with a trailing slash (/services/billing/)
+type pathPattern struct {
+	path string
+	dir  bool
+}
+
+// parsePathPattern normalises a path pattern. Repeated slashes, "." and
+// ".." segments and backslashes are cleaned up; "/" matches everything.
+func parsePathPattern(pattern string) pathPattern {
+	pattern = strings.ReplaceAll(pattern, `\`, "/")
+	cleaned := strings.TrimPrefix(path.Clean("/"+pattern), "/")
+	return pathPattern{
+		path: cleaned,
+		dir:  cleaned != "" && strings.HasSuffix(pattern, "/"),
+	}
+}
+
+// match reports whether the slash separated relative path matches
+func (p pathPattern) match(relativePath string) bool {
+	if p.path == "" || strings.HasPrefix(relativePath, p.path+"/") {
+		return true
+	}
+	return !p.dir && relativePath == p.path
+}
+
+// reaches reports whether the pattern can match a path below dir
+func (p pathPattern) reaches(dir string) bool {
+	if p.path == "" {
+		return true
+	}
+	prefix := p.path + "/"
+	return strings.HasPrefix(dir+"/", prefix) || strings.HasPrefix(prefix, dir+"/")
+}
@@ -0,0 +1,335 @@
+package concat
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCompilePatternKind(t *testing.T) {
+	tests := []struct {
+		pattern string
+		kind    PatternKind
+		match   string
+	}{
+		{"*.go", GlobPattern, "cmd/main.go"},
+		{"src/**/*.ts", GlobPattern, "src/a/b.ts"},
+		{"*.{ts,tsx}", GlobPattern, "ui/app.tsx"},
+		{"[!_]*.py", GlobPattern, "pkg/main.py"},
+		{"glob:file?.txt", GlobPattern, "file1.txt"},
+		{"glob:[Tt]est", GlobPattern, "src/Test"},
+		// ? [ and { without a * are regexes
+		{"colou?r", RegexPattern, "src/color.go"},
+		{"[Tt]est", RegexPattern, "src/Testing.go"},
+		{"[Tt]ests/", RegexPattern, "pkg/tests/a.go"},
+		{"file?.txt", RegexPattern, "fil.txt"},
+		{`^a{2}\.go$`, RegexPattern, "aa.go"},
+		{".*_test.go", RegexPattern, "pkg/a_test.go"},
+		{"regex:a*b", RegexPattern, "xaaab"},
+		{"/src/", PathPattern, "src/main.go"},
+		{"gitignore:build/", GitignorePattern, "build/out.js"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			p, err := CompilePattern(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Kind != tt.kind {
+				t.Errorf("Kind = %s, want %s", p.Kind, tt.kind)
+			}
+			if !p.Match(tt.match) {
+				t.Errorf("does not match %q", tt.match)
+			}
+		})
+	}
+}
+
+func TestPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"/util", "util", true},
+		{"/util", "util/strings.go", true},
+		{"/util", "util/deep/er.go", true},
+		{"/util", "utilities/x.go", false},
+		{"/util", "utils/x.go", false},
+		{"/util", "util.go", false},
+		{"/util", "lib/util/x.go", false},
+		{"/src/api", "src/api/handler.go", true},
+		{"/src/api", "src/apiv2/handler.go", false},
+		{"/src/api", "src/api.go", false},
+		{"/cmd/main.go", "cmd/main.go", true},
+		{"/cmd/main.go", "cmd/main.gox", false},
+		{"/cmd/main.go", "cmd/main.go.orig", false},
+		{"/src/", "src/main.go", true},
+		{"/src/", "src", false},
+		{"/src/", "srcs/main.go", false},
+		{"/services/billing/", "services/billing/api.go", true},
+		{"/services/billing/", "services/billing-v2/api.go", false},
+		{"//src/./api/../api", "src/api/x.go", true},
+		{`\src\api`, "src/api/x.go", true},
+		{"/", "anything/at/all.go", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			if got := parsePathPattern(tt.pattern).match(tt.path); got != tt.match {
+				t.Errorf("match(%q) = %v, want %v", tt.path, got, tt.match)
+			}
+		})
+	}
+}
+
+func TestPathPatternReaches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		dir     string
+		reaches bool
+	}{
+		{"/pkg/server", "pkg", true},
+		{"/pkg/server", "pkg/server", true},
+		{"/pkg/server", "pkg/server/api", true},
+		{"/pkg/server", "pkg/serverless", false},
+		{"/pkg/server", "pk", false},
+		{"/pkg/server/", "pkg/server/api", true},
+		{"/cmd/main.go", "cmd", true},
+		{"/cmd/main.go", "cmdx", false},
+		{"/", "docs", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.dir, func(t *testing.T) {
+			if got := parsePathPattern(tt.pattern).reaches(tt.dir); got != tt.reaches {
+				t.Errorf("reaches(%q) = %v, want %v", tt.dir, got, tt.reaches)
+			}
+		})
+	}
+}
+
+func TestMatcherMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		path     string
+		selected bool
+		rule     Rule
+		pattern  string
+	}{
+		{"no patterns", nil, nil, "src/main.go", true, RuleIncluded, ""},
+		{"regex on the path", nil, []string{`_test\.go$`}, "pkg/a_test.go", false, RuleExcluded, `_test\.go$`},
+		{"regex on the base name", nil, []string{`^Makefile$`}, "build/Makefile", false, RuleExcluded, `^Makefile$`},
+		{"regex not matching", nil, []string{`_test\.go$`}, "pkg/a.go", true, RuleIncluded, ""},
+		{"default exclusion", nil, nil, "web/node_modules/react/index.js", false, RuleDefault, `node_modules/`},
+		{"exclude before default", nil, []string{`node_modules`}, "node_modules/x.js", false, RuleExcluded, `node_modules`},
+		{"include matches", []string{`\.go$`}, nil, "cmd/main.go", true, RuleIncluded, `\.go$`},
+		{"include misses", []string{`\.go$`}, nil, "README.md", false, RuleNotIncluded, ""},
+		{"exclude wins over include", []string{`\.go$`}, []string{`^gen/`}, "gen/api.go", false, RuleExcluded, `^gen/`},
+		{"glob prefix on the base name", nil, []string{"glob:*.snap"}, "ui/__snapshots__/a.snap", false, RuleExcluded, "glob:*.snap"},
+		{"glob prefix anchored at the root", []string{"glob:src/**/*.ts"}, nil, "lib/src/a.ts", false, RuleNotIncluded, ""},
+		{"glob double star", []string{"glob:src/**/*.ts"}, nil, "src/a/b/c.ts", true, RuleIncluded, "glob:src/**/*.ts"},
+		{"path pattern directory", []string{"/services/billing/"}, nil, "services/billing/api.go", true, RuleIncluded, "/services/billing/"},
+		{"path pattern other directory", []string{"/services/billing/"}, nil, "services/billing-v2/api.go", false, RuleNotIncluded, ""},
+		{"path pattern file", nil, []string{"/cmd/main.go"}, "cmd/main.go", false, RuleExcluded, "/cmd/main.go"},
+		{"path pattern longer name", nil, []string{"/cmd/main.go"}, "cmd/main.gox", true, RuleIncluded, ""},
+		{"gitignore rule", nil, []string{"gitignore:build/"}, "app/build/out.js", false, RuleExcluded, "gitignore:build/"},
+		{"gitignore rule on a file", nil, []string{"gitignore:/TODO"}, "TODO", false, RuleExcluded, "gitignore:/TODO"},
+		{"gitignore rule anchored", nil, []string{"gitignore:/TODO"}, "docs/TODO", true, RuleIncluded, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMatcher(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			selected, reason := m.Match(tt.path)
+			pattern := ""
+			if reason.Pattern != nil {
+				pattern = reason.Pattern.Source
+			}
+			if selected != tt.selected || reason.Rule != tt.rule || pattern != tt.pattern {
+				t.Errorf("Match(%q) = %v, %q %q; want %v, %q %q", tt.path, selected, reason.Rule, pattern, tt.selected, tt.rule, tt.pattern)
+			}
+		})
+	}
+}
+
+func TestMatcherSkipDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		dir     string
+		skip    bool
+		rule    Rule
+	}{
+		{"nothing excludes", nil, nil, "src", false, RuleIncluded},
+		{"default exclusion", nil, nil, "web/node_modules", true, RuleDefault},
+		{"git directory", nil, nil, ".git", true, RuleDefault},
+		{"regex covering the directory", nil, []string{`^vendor/`}, "vendor", true, RuleExcluded},
+		{"regex on files only", nil, []string{`\.go$`}, "vendor", false, RuleIncluded},
+		{"regex deeper than the directory", nil, []string{`^vendor/github\.com/`}, "vendor", false, RuleIncluded},
+		{"regex covering a parent", nil, []string{`^vendor/`}, "vendor/github.com/x", true, RuleExcluded},
+		{"glob ending in /**", nil, []string{"glob:dist/**"}, "dist/assets", true, RuleExcluded},
+		{"glob of files", nil, []string{"glob:dist/*.js"}, "dist", false, RuleIncluded},
+		{"gitignore directory rule", nil, []string{"gitignore:build/"}, "app/build", true, RuleExcluded},
+		{"path include elsewhere", []string{"/pkg/server/"}, nil, "docs", true, RuleNotIncluded},
+		{"path include parent", []string{"/pkg/server/"}, nil, "pkg", false, RuleIncluded},
+		{"path include inside", []string{"/pkg/server/"}, nil, "pkg/server/api", false, RuleIncluded},
+		{"path include sibling", []string{"/pkg/server/"}, nil, "pkg/serverless", true, RuleNotIncluded},
+		{"path include without slash sibling", []string{"/pkg/server"}, nil, "pkg/serverless", true, RuleNotIncluded},
+		{"regex include may match anywhere", []string{"/pkg/server/", `\.md$`}, nil, "docs", false, RuleIncluded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMatcher(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if skip, reason := m.SkipDir(tt.dir); skip != tt.skip || reason.Rule != tt.rule {
+				t.Errorf("SkipDir(%q) = %v, %q; want %v, %q", tt.dir, skip, reason.Rule, tt.skip, tt.rule)
+			}
+		})
+	}
+}
+
+// TestCoversDir checks that a pattern only claims a directory when it
+// matches every path below it, which SkipDir relies on to prune the walk
+func TestCoversDir(t *testing.T) {
+	tests := []struct {
+		pattern string
+		dir     string
+		covers  bool
+	}{
+		// Unanchored regexes match "dir/x" wherever they match "dir/"
+		{`node_modules/`, "web/node_modules", true},
+		{`^build`, "build", true},
+		{`^build`, "buildtools", true},
+		{`^[^/]+/`, "anything", true},
+		{`(?i)^VENDOR/`, "vendor", true},
+		{`^src/a`, "src", false},
+		{`x/.`, "x", false},
+		// Anchors at the end or word boundaries may fail below dir
+		{`^build/$`, "build", false},
+		{`^build/\z`, "build", false},
+		{`build\b`, "build", false},
+		{`build/\B`, "build", false},
+		{`\.go$`, "pkg", false},
+		// Globs only cover with a trailing /**
+		{"glob:dist/**", "dist", true},
+		{"glob:dist/**", "dist/a/b", true},
+		{"glob:dist/**", "distro", false},
+		{"glob:dist/*", "dist", false},
+		{"glob:**/testdata/**", "pkg/x/testdata", true},
+		// Gitignore rules cover the directories they match
+		{"gitignore:build/", "a/build", true},
+		{"gitignore:build/", "a/build/sub", true},
+		{"gitignore:*.log", "logs", false},
+		// Path patterns with a trailing slash cover their directory
+		{"/services/billing/", "services/billing", true},
+		{"/services/billing/", "services/billing/api", true},
+		{"/services/billing/", "services", false},
+		{"/util", "util", true},
+		{"/util", "utilities", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.dir, func(t *testing.T) {
+			p, err := CompilePattern(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.coversDir(tt.dir); got != tt.covers {
+				t.Fatalf("coversDir(%q) = %v, want %v", tt.dir, got, tt.covers)
+			}
+			// Whatever a pattern covers, it must match every file below
+			if tt.covers {
+				for _, file := range []string{tt.dir + "/a.go", tt.dir + "/deep/er/b.txt", tt.dir + "/.hidden"} {
+					if !p.Match(file) {
+						t.Errorf("covers %q but does not match %q", tt.dir, file)
+					}
+				}
+			}
+		})
+	}
+}
+
+// benchPaths generates n paths of a monorepo-like tree
+func benchPaths(n int) []string {
+	dirs := []string{"src/app", "pkg/server/api", "pkg/client", "web/node_modules/react/lib", "docs/guide", "vendor/github.com/acme/lib", "internal/store/v1"}
+	exts := []string{".go", "_test.go", ".ts", ".md", ".json", ".png", ".min.js", ".py"}
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("%s/mod%02d/file%d%s", dirs[i%len(dirs)], i/len(dirs)%50, i, exts[i%len(exts)])
+	}
+	return paths
+}
+
+// The benchmark patterns are regexes, the only kind matching used to
+// support
+var (
+	benchInclude = []string{`\.(go|ts|py|md|json)$`}
+	benchExclude = []string{`_test\.go$`, `^vendor/`, `\.min\.js$`, `^docs/`, `internal/.*/v1/`}
+)
+
+// matchCompiling is matching as it was before Matcher: every pattern is
+// compiled again for every path
+func matchCompiling(relativePath string, include, exclude []string) bool {
+	baseName := relativePath[strings.LastIndex(relativePath, "/")+1:]
+	matches := func(pattern string) bool {
+		regex := regexp.MustCompile(pattern)
+		return regex.MatchString(relativePath) || regex.MatchString(baseName)
+	}
+	for _, pattern := range exclude {
+		if matches(pattern) {
+			return false
+		}
+	}
+	for _, pattern := range DefaultExclusions {
+		if matches(pattern) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, pattern := range include {
+		if matches(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// BenchmarkMatcher compares a Matcher with compiling the patterns for every
+// path, over a 100k file tree
+func BenchmarkMatcher(b *testing.B) {
+	paths := benchPaths(100_000)
+
+	m, err := NewMatcher(benchInclude, benchExclude)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, p := range paths[:1000] {
+		if selected, _ := m.Match(p); selected != matchCompiling(p, benchInclude, benchExclude) {
+			b.Fatalf("Matcher and per-call compilation disagree on %s", p)
+		}
+	}
+
+	b.Run("Matcher", func(b *testing.B) {
+		for b.Loop() {
+			m, _ := NewMatcher(benchInclude, benchExclude)
+			for _, p := range paths {
+				m.Match(p)
+			}
+		}
+	})
+	b.Run("CompilePerCall", func(b *testing.B) {
+		for b.Loop() {
+			for _, p := range paths {
+				matchCompiling(p, benchInclude, benchExclude)
+			}
+		}
+	})
+}
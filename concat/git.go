@@ -0,0 +1,355 @@
+package concat
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GitSource is a remote git repository. Clones are cached for a few minutes
+// so repeated runs against the same repository skip the network.
+type GitSource struct {
+	// URL is anything ParseRepoURL accepts.
+	URL string
+	// Ref is a branch, tag or commit to check out. Empty means the ref
+	// named by a browser URL, or else the remote's default branch.
+	Ref string
+	// Paths are path patterns (/dir) bounding the files of interest. When
+	// set, the clone is shallow, blob-less and sparse so that only blobs
+	// which can survive filtering are fetched. See SparsePaths.
+	Paths []string
+
+	// Auth holds credentials for private repositories.
+	Auth Auth
+
+	// Stdout and Stderr receive git's own output. Nil discards it.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Notify receives status messages about caching and cloning.
+	Notify func(level, message string)
+
+	// auth is Auth resolved for the repository's host
+	auth Auth
+}
+
+func (s *GitSource) notify(level, message string) {
+	if s.Notify != nil {
+		s.Notify(level, message)
+	}
+}
+
+// Resolve implements Source.
+func (s *GitSource) Resolve() (*Tree, error) {
+	tree, err := s.resolve()
+	if err != nil {
+		// git may echo credentials back in its error output
+		return nil, fmt.Errorf("%s", s.auth.redact(err.Error()))
+	}
+	return tree, nil
+}
+
+func (s *GitSource) resolve() (*Tree, error) {
+	repo, err := ParseRepoURL(s.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	s.auth = s.Auth
+	if s.auth.Token == "" && repo.token != "" {
+		s.auth.Token, s.auth.Username = repo.token, repo.username
+	}
+	if s.auth, err = s.auth.resolve(repo); err != nil {
+		return nil, err
+	}
+	if os.Getenv("REPO_CONCAT_TOKEN") != "" && os.Getenv("REPO_CONCAT_TOKEN_HOST") == "" {
+		s.notify("warning", "REPO_CONCAT_TOKEN is ignored until REPO_CONCAT_TOKEN_HOST names the hosts it is for")
+	}
+
+	ref := s.Ref
+	if ref == "" && len(repo.webPath) > 1 {
+		if refs, err := s.listRemoteRefs(repo.CloneURL); err == nil {
+			repo.resolveWebRef(refs)
+		}
+	}
+	if ref == "" {
+		ref = repo.Ref
+	}
+
+	tree := &Tree{
+		SourceInfo: SourceInfo{
+			Name:       repo.Name,
+			Repository: repo.Repository(),
+			URL:        repo.Raw,
+			Ref:        ref,
+			Subdir:     repo.Subdir,
+		},
+	}
+
+	// A full clone of the same ref also satisfies a sparse request
+	fullKey := cacheKey(repo.cacheID(), ref)
+	key := fullKey
+	lookup := []string{fullKey}
+	if s.sparse(repo.Subdir) {
+		paths := append([]string{}, s.Paths...)
+		sort.Strings(paths)
+		key = cacheKey(append([]string{repo.cacheID(), ref, repo.Subdir}, paths...)...)
+		lookup = append(lookup, key)
+	}
+
+	for _, k := range lookup {
+		entry, err := getCachedRepo(k)
+		if err != nil {
+			s.notify("warning", fmt.Sprintf("Cache check failed: %v", err))
+			break
+		}
+		if entry != nil {
+			age := time.Since(entry.CachedAt)
+			s.notify("success", fmt.Sprintf("Using cached repository (cached %s ago)", formatDuration(age)))
+			tree.Root = entry.RepoPath
+			tree.Commit = entry.Commit
+			tree.FS = os.DirFS(entry.RepoPath)
+			return tree, nil
+		}
+	}
+
+	tempDir, err := os.MkdirTemp("", "repo-concat-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	s.notify("loading", "Cloning repository: "+repo.Raw)
+
+	repoPath := filepath.Join(tempDir, tree.Name)
+	var commit string
+	if s.sparse(repo.Subdir) {
+		commit, err = s.sparseClone(repo.CloneURL, ref, repo.Subdir, repoPath)
+	} else {
+		commit, err = s.fullClone(repo.CloneURL, ref, repoPath)
+	}
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return nil, err
+	}
+
+	s.notify("success", "Repository cloned successfully")
+
+	tree.Root = repoPath
+	tree.Commit = commit
+	tree.cleanup = func() error { return os.RemoveAll(tempDir) }
+
+	// Move the clone into the cache; on failure keep using the temp copy
+	cacheDir := getTmpCacheDir()
+	if err := os.MkdirAll(cacheDir, 0755); err == nil {
+		cachedRepoPath := filepath.Join(cacheDir, key)
+		if err := os.RemoveAll(cachedRepoPath); err == nil {
+			if err := os.Rename(repoPath, cachedRepoPath); err == nil {
+				os.RemoveAll(tempDir)
+				tree.Root = cachedRepoPath
+				tree.cleanup = nil
+				entry := CacheEntry{URL: repo.Raw, Ref: ref, Commit: commit, Paths: s.Paths, RepoPath: cachedRepoPath}
+				if err := cacheRepo(key, entry); err != nil {
+					s.notify("warning", fmt.Sprintf("Failed to cache repository metadata: %v", err))
+				}
+			}
+		}
+	}
+
+	tree.FS = os.DirFS(tree.Root)
+	return tree, nil
+}
+
+// fullClone clones the whole history into repoPath and checks out ref
+func (s *GitSource) fullClone(cloneURL, ref, repoPath string) (string, error) {
+	if err := s.git("", "clone", cloneURL, repoPath); err != nil {
+		return "", fmt.Errorf("failed to clone repository: %w", err)
+	}
+	return checkoutRef(repoPath, ref)
+}
+
+// sparse reports whether a sparse clone can be used, which is the case
+// when path patterns or a browser URL's subdirectory bound the files.
+func (s *GitSource) sparse(subdir string) bool {
+	return len(s.Paths) > 0 || subdir != ""
+}
+
+// sparseClone fetches only the tip of ref without blobs, restricts the
+// checkout to the directories that can contain files within subdir that
+// match Paths and checks it out, which fetches just the blobs inside
+// those directories.
+func (s *GitSource) sparseClone(cloneURL, ref, subdir, repoPath string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	steps := [][]string{
+		{"init", "--quiet", repoPath},
+		{"-C", repoPath, "remote", "add", "origin", cloneURL},
+		{"-C", repoPath, "fetch", "--depth", "1", "--filter=blob:none", "origin", ref},
+	}
+	for _, args := range steps {
+		if err := s.git("", args...); err != nil {
+			return "", fmt.Errorf("failed to clone repository: %w", err)
+		}
+	}
+
+	commit, err := runGit(repoPath, "rev-parse", "--verify", "FETCH_HEAD^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("ref not found in repository: %s", ref)
+	}
+
+	listing, err := runGit(repoPath, "ls-tree", "-r", "-z", "--name-only", commit)
+	if err != nil {
+		return "", fmt.Errorf("failed to list repository files: %w", err)
+	}
+	cones := sparseCones(strings.Split(listing, "\x00"), subdir, s.Paths)
+
+	if err := s.git(repoPath, append([]string{"sparse-checkout", "set", "--cone"}, cones...)...); err != nil {
+		return "", fmt.Errorf("failed to configure sparse checkout: %w", err)
+	}
+	if err := s.git(repoPath, "checkout", "--quiet", "--detach", commit); err != nil {
+		return "", fmt.Errorf("failed to check out %s: %w", ref, err)
+	}
+
+	return commit, nil
+}
+
+// git runs an authenticated git command in dir, forwarding its output
+func (s *GitSource) git(dir string, args ...string) error {
+	cmd := exec.Command("git", append(s.auth.gitArgs(), args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), s.auth.gitEnv()...)
+	cmd.Stdout = s.Stdout
+	cmd.Stderr = s.Stderr
+	return cmd.Run()
+}
+
+// SparsePaths returns the include patterns that can bound a sparse clone.
+// It returns nil unless every include pattern is a path pattern, since any
+// other pattern may match files anywhere in the repository.
+func SparsePaths(include []string) []string {
+	if len(include) == 0 {
+		return nil
+	}
+	for _, pattern := range include {
+		if !isPathPattern(pattern) {
+			return nil
+		}
+	}
+	return include
+}
+
+// sparseCones returns the minimal set of cone directories holding every
+// file within subdir that matches one of the path patterns. Files directly
+// in the root are always checked out in cone mode, so they need no cone.
+func sparseCones(files []string, subdir string, paths []string) []string {
+	patterns := make([]pathPattern, len(paths))
+	for i, pattern := range paths {
+		patterns[i] = parsePathPattern(pattern)
+	}
+
+	dirs := make(map[string]bool)
+	for _, file := range files {
+		if file == "" || !inScope(file, subdir) {
+			continue
+		}
+		matched := len(paths) == 0
+		for _, pattern := range patterns {
+			if pattern.match(file) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			if dir := path.Dir(file); dir != "." {
+				dirs[dir] = true
+			}
+		}
+	}
+
+	var cones []string
+	for dir := range dirs {
+		covered := false
+		for parent := path.Dir(dir); parent != "."; parent = path.Dir(parent) {
+			if dirs[parent] {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			cones = append(cones, dir)
+		}
+	}
+	sort.Strings(cones)
+	return cones
+}
+
+// checkoutRef checks out ref in the clone at repoPath and returns the
+// resolved commit SHA. An empty ref keeps the default branch.
+func checkoutRef(repoPath, ref string) (string, error) {
+	if ref != "" {
+		commit, err := resolveRef(repoPath, ref)
+		if err != nil {
+			return "", err
+		}
+		if _, err := runGit(repoPath, "checkout", "--quiet", "--detach", commit); err != nil {
+			return "", fmt.Errorf("failed to check out %s: %w", ref, err)
+		}
+	}
+
+	commit, err := runGit(repoPath, "rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	return commit, nil
+}
+
+// resolveRef finds the commit a branch, tag or SHA names. Branches other
+// than the default only exist as remote-tracking refs after a clone.
+func resolveRef(repoPath, ref string) (string, error) {
+	for _, candidate := range []string{ref, "origin/" + ref} {
+		if commit, err := runGit(repoPath, "rev-parse", "--verify", "--quiet", candidate+"^{commit}"); err == nil {
+			return commit, nil
+		}
+	}
+	return "", fmt.Errorf("ref not found in repository: %s", ref)
+}
+
+// listRemoteRefs returns the branch and tag names advertised by a remote
+func (s *GitSource) listRemoteRefs(cloneURL string) (map[string]bool, error) {
+	var out strings.Builder
+	cmd := exec.Command("git", append(s.auth.gitArgs(), "ls-remote", "--heads", "--tags", cloneURL)...)
+	cmd.Env = append(os.Environ(), s.auth.gitEnv()...)
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]bool)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			refs[strings.TrimSuffix(fields[1], "^{}")] = true
+		}
+	}
+	return refs, nil
+}
+
+// runGit runs a git command in dir and returns its trimmed output
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,215 @@
+package concat
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// gitRepo builds a bare repository in a temporary directory from commits of
+// files, and returns its file:// URL and the SHA of each commit
+func gitRepo(t *testing.T, commits ...map[string]string) (string, []string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	oldCacheRoot := cacheRoot
+	cacheRoot = t.TempDir()
+	t.Cleanup(func() { cacheRoot = oldCacheRoot })
+
+	work := t.TempDir()
+	bare := filepath.Join(t.TempDir(), "repo.git")
+	run := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	run(work, "init", "--quiet", "--initial-branch=main")
+	var shas []string
+	for _, files := range commits {
+		for name, content := range files {
+			file := filepath.Join(work, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		run(work, "add", "-A")
+		run(work, "commit", "--quiet", "-m", "commit")
+		shas = append(shas, run(work, "rev-parse", "HEAD"))
+	}
+	run("", "clone", "--quiet", "--bare", work, bare)
+	// Let the blob-less clone fetch its blobs on demand
+	run(bare, "config", "uploadpack.allowFilter", "true")
+	return "file://" + bare, shas
+}
+
+// checkedOut lists the files of a clone outside .git
+func checkedOut(t *testing.T, root string) []string {
+	t.Helper()
+	var files []string
+	err := fs.WalkDir(os.DirFS(root), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p == ".git" {
+			return fs.SkipDir
+		}
+		if !d.IsDir() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestSparseCloneLocalBareRepository(t *testing.T) {
+	url, shas := gitRepo(t,
+		map[string]string{
+			"README.md":             "v1\n",
+			"pkg/server/server.go":  "package server // v1\n",
+			"pkg/server/api/api.go": "package api\n",
+			"pkg/client/client.go":  "package client\n",
+			"cmd/main.go":           "package main\n",
+		},
+		map[string]string{
+			"README.md":            "v2\n",
+			"pkg/server/server.go": "package server // v2\n",
+		},
+	)
+
+	tests := []struct {
+		name   string
+		ref    string
+		commit string
+		server string
+		readme string
+	}{
+		{
+			name:   "default branch",
+			commit: shas[1],
+			server: "package server // v2\n",
+			readme: "v2\n",
+		},
+		{
+			name:   "ref SHA",
+			ref:    shas[0],
+			commit: shas[0],
+			server: "package server // v1\n",
+			readme: "v1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &GitSource{URL: url, Ref: tt.ref, Paths: SparsePaths([]string{"/pkg/server/"})}
+			tree, err := source.Resolve()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+
+			if tree.Commit != tt.commit {
+				t.Errorf("Commit = %s, want %s", tree.Commit, tt.commit)
+			}
+			cones, err := runGit(tree.Root, "sparse-checkout", "list")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cones != "pkg/server" {
+				t.Errorf("sparse-checkout list = %q, want %q", cones, "pkg/server")
+			}
+			// Files in the root are always checked out in cone mode
+			want := []string{"README.md", "pkg/server/api/api.go", "pkg/server/server.go"}
+			if got := checkedOut(t, tree.Root); !reflect.DeepEqual(got, want) {
+				t.Errorf("checked out %v, want %v", got, want)
+			}
+			for file, content := range map[string]string{"pkg/server/server.go": tt.server, "README.md": tt.readme} {
+				data, err := os.ReadFile(filepath.Join(tree.Root, file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != content {
+					t.Errorf("%s = %q, want %q", file, data, content)
+				}
+			}
+		})
+	}
+}
+
+func TestFullCloneRefSHA(t *testing.T) {
+	url, shas := gitRepo(t,
+		map[string]string{"main.go": "package main // v1\n"},
+		map[string]string{"main.go": "package main // v2\n"},
+	)
+	tree, err := (&GitSource{URL: url, Ref: shas[0]}).Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if tree.Commit != shas[0] {
+		t.Errorf("Commit = %s, want %s", tree.Commit, shas[0])
+	}
+	data, err := os.ReadFile(filepath.Join(tree.Root, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "package main // v1\n" {
+		t.Errorf("main.go = %q, want the first commit", data)
+	}
+}
+
+func TestSparseCones(t *testing.T) {
+	files := []string{
+		"README.md",
+		"cmd/main.go",
+		"pkg/client/client.go",
+		"pkg/server/server.go",
+		"pkg/server/api/api.go",
+		"pkg/serverless/handler.go",
+		"docs/guide/intro.md",
+	}
+	tests := []struct {
+		name   string
+		subdir string
+		paths  []string
+		want   []string
+	}{
+		{"directory", "", []string{"/pkg/server/"}, []string{"pkg/server"}},
+		{"nested directories collapse", "", []string{"/pkg/server/", "/pkg/server/api/"}, []string{"pkg/server"}},
+		{"several directories", "", []string{"/cmd/", "/docs/"}, []string{"cmd", "docs/guide"}},
+		{"single file", "", []string{"/cmd/main.go"}, []string{"cmd"}},
+		{"no slash skips siblings", "", []string{"/pkg/server"}, []string{"pkg/server"}},
+		{"root file needs no cone", "", []string{"/README.md"}, nil},
+		{"subdir only", "pkg/server", nil, []string{"pkg/server"}},
+		{"subdir and paths", "pkg", []string{"/pkg/client/"}, []string{"pkg/client"}},
+		{"no match", "", []string{"/missing/"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sparseCones(files, tt.subdir, tt.paths); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sparseCones(%q, %q) = %v, want %v", tt.subdir, tt.paths, got, tt.want)
+			}
+		})
+	}
+}
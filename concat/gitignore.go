@@ -0,0 +1,240 @@
+package concat
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// gitignore applies the ignore rules git itself would use for a tree:
+// .git/info/exclude and the .gitignore file of every directory, with
+// negation, anchoring, ** and directory-only rules. Rule files are read
+// lazily as directories are visited.
+//
+// The same lookup serves other per-directory rule files, see
+// newGitattributes.
+type gitignore struct {
+	fsys fs.FS
+	// name is the rule file of each directory and info the one below
+	// .git/info, read with parse
+	name  string
+	info  string
+	parse func(data []byte, file string) []ignoreRule
+	// rules holds the parsed .gitignore of each directory, "" being
+	// .git/info/exclude
+	rules map[string][]ignoreRule
+	// dirs caches the rule ignoring each directory, nil if it is not
+	dirs map[string]*ignoreRule
+}
+
+// ignoreRule is one line of an ignore file
+type ignoreRule struct {
+	regex   *regexp.Regexp
+	negate  bool
+	dirOnly bool
+
+	// file and line locate the rule, to explain decisions
+	file string
+	line string
+}
+
+// newIgnoreFile matches the rules of a single ignore file at the root,
+// such as .repoconcatignore
+func newIgnoreFile(rules []ignoreRule) *gitignore {
+	return &gitignore{
+		rules: map[string][]ignoreRule{"": rules},
+		dirs:  make(map[string]*ignoreRule),
+	}
+}
+
+func newGitignore(fsys fs.FS) *gitignore {
+	return &gitignore{
+		fsys:  fsys,
+		name:  ".gitignore",
+		info:  ".git/info/exclude",
+		parse: parseIgnoreRules,
+		rules: make(map[string][]ignoreRule),
+		dirs:  make(map[string]*ignoreRule),
+	}
+}
+
+// match returns the rule that makes git ignore the file at the slash
+// separated relative path, or nil. A file inside an ignored directory is
+// always ignored, as git cannot re-include it with a negated rule.
+func (g *gitignore) match(relativePath string) *ignoreRule {
+	if dir := path.Dir(relativePath); dir != "." {
+		if rule := g.matchDir(dir); rule != nil {
+			return rule
+		}
+	}
+	return g.ignored(relativePath, false)
+}
+
+// matchDir is match for directories
+func (g *gitignore) matchDir(dir string) *ignoreRule {
+	if rule, ok := g.dirs[dir]; ok {
+		return rule
+	}
+	var rule *ignoreRule
+	if parent := path.Dir(dir); parent != "." {
+		rule = g.matchDir(parent)
+	}
+	if rule == nil {
+		rule = g.ignored(dir, true)
+	}
+	g.dirs[dir] = rule
+	return rule
+}
+
+// ignored applies the rules of every directory above p, the deepest
+// .gitignore taking precedence and the last matching line winning
+func (g *gitignore) ignored(p string, isDir bool) *ignoreRule {
+	var last *ignoreRule
+	apply := func(rules []ignoreRule, rel string) {
+		for i, rule := range rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.regex.MatchString(rel) {
+				last = &rules[i]
+			}
+		}
+	}
+
+	apply(g.load(""), p)
+
+	parts := strings.Split(p, "/")
+	for i := 0; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		if dir == "" {
+			dir = "."
+		}
+		apply(g.load(dir), strings.Join(parts[i:], "/"))
+	}
+
+	if last == nil || last.negate {
+		return nil
+	}
+	return last
+}
+
+// load returns the rules of dir's .gitignore, or of .git/info/exclude for ""
+func (g *gitignore) load(dir string) []ignoreRule {
+	if rules, ok := g.rules[dir]; ok || g.fsys == nil {
+		return rules
+	}
+	name := path.Join(dir, g.name)
+	if dir == "" {
+		name = g.info
+	}
+	var rules []ignoreRule
+	if data, err := fs.ReadFile(g.fsys, name); err == nil {
+		rules = g.parse(data, name)
+	}
+	g.rules[dir] = rules
+	return rules
+}
+
+// parseIgnoreRules parses the lines of the gitignore file named file
+func parseIgnoreRules(data []byte, file string) []ignoreRule {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			rule.file = file
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{line: line}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// A slash anywhere but at the end anchors the pattern to the directory
+	// of the ignore file; otherwise it matches at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := ignoreToRegex(line)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	regex, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.regex = regex
+	return rule, true
+}
+
+// ignoreToRegex translates a gitignore pattern into a regular expression
+func ignoreToRegex(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**") && (i == 0 || pattern[i-1] == '/') {
+				switch {
+				case i+2 == len(pattern):
+					// Trailing /** matches everything inside
+					b.WriteString(".*")
+					i++
+					continue
+				case pattern[i+2] == '/':
+					// Leading **/ and inner /**/ match zero or more directories
+					b.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				}
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
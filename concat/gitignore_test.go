@@ -0,0 +1,123 @@
+package concat
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGitignore(t *testing.T) {
+	file := func(content string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(content)} }
+	fsys := fstest.MapFS{
+		".git/info/exclude": file("secret.txt\nlocal.env\n"),
+		".gitignore": file(strings.Join([]string{
+			"# build output",
+			"*.log",
+			"!keep.log",
+			"/anchored",
+			"dir/",
+			"a/**/x",
+			"build/",
+			"!build/keep.txt",
+			"!local.env",
+		}, "\n")),
+		"sub/.gitignore":        file("*.tmp\n!debug.log\n"),
+		"only-go/.gitignore":    file("*\n!*/\n!*.go\n"),
+		"app.log":               file(""),
+		"keep.log":              file(""),
+		"sub/debug.log":         file(""),
+		"sub/other.log":         file(""),
+		"sub/x.tmp":             file(""),
+		"x.tmp":                 file(""),
+		"anchored":              file(""),
+		"sub/anchored":          file(""),
+		"dir":                   file(""),
+		"sub/dir/a.go":          file(""),
+		"a/x":                   file(""),
+		"a/b/c/x":               file(""),
+		"b/a/x":                 file(""),
+		"build/out.js":          file(""),
+		"build/keep.txt":        file(""),
+		"secret.txt":            file(""),
+		"local.env":             file(""),
+		"only-go/main.go":       file(""),
+		"only-go/README.md":     file(""),
+		"only-go/pkg/server.go": file(""),
+		"only-go/pkg/notes.txt": file(""),
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		// rule is the line of the rule ignoring path, "" if it is kept
+		rule string
+	}{
+		{path: "app.log", rule: "*.log"},
+		{path: "keep.log"},
+		// A nested .gitignore overrides its parents
+		{path: "sub/debug.log"},
+		{path: "sub/other.log", rule: "*.log"},
+		{path: "sub/x.tmp", rule: "*.tmp"},
+		{path: "x.tmp"},
+		{path: "anchored", rule: "/anchored"},
+		{path: "sub/anchored"},
+		// Directory rules leave files of the same name alone
+		{path: "dir"},
+		{path: "sub/dir", isDir: true, rule: "dir/"},
+		{path: "sub/dir/a.go", rule: "dir/"},
+		{path: "a/x", rule: "a/**/x"},
+		{path: "a/b/c/x", rule: "a/**/x"},
+		{path: "b/a/x"},
+		// A file in an ignored directory cannot be re-included
+		{path: "build", isDir: true, rule: "build/"},
+		{path: "build/out.js", rule: "build/"},
+		{path: "build/keep.txt", rule: "build/"},
+		// .git/info/exclude comes before every .gitignore
+		{path: "secret.txt", rule: "secret.txt"},
+		{path: "local.env"},
+		// The whitelist idiom keeps directories and Go files only
+		{path: "only-go/main.go"},
+		{path: "only-go/README.md", rule: "*"},
+		{path: "only-go/pkg", isDir: true},
+		{path: "only-go/pkg/server.go"},
+		{path: "only-go/pkg/notes.txt", rule: "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			g := newGitignore(fsys)
+			var rule *ignoreRule
+			if tt.isDir {
+				rule = g.matchDir(tt.path)
+			} else {
+				rule = g.match(tt.path)
+			}
+			got := ""
+			if rule != nil {
+				got = rule.line
+			}
+			if got != tt.rule {
+				t.Errorf("rule = %q, want %q", got, tt.rule)
+			}
+		})
+	}
+
+	// Walker.SkipDir prunes directories with matchDir, which must only
+	// claim directories whose every file match ignores
+	g := newGitignore(fsys)
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if g.matchDir(dir) != nil && g.match(p) == nil {
+				t.Errorf("%s is ignored but %s is not", dir, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,167 @@
+package concat
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// regexQuantifier matches regex repetition such as {2} or {1,3}, which
+// would otherwise read as glob braces
+var regexQuantifier = regexp.MustCompile(`\{\d*(,\d*)?\}`)
+
+// isGlobPattern reports whether pattern reads as a shell glob such as *.go
+// or src/**/*.{ts,tsx} rather than a regular expression such as .*\.go$.
+// A pattern is a glob when it uses the * wildcard and none of the
+// constructs that only make sense in a regex. ? [ and { alone are valid
+// regex syntax, as in colou?r or [Tt]ests/, so they keep a pattern a regex
+// unless it has a "glob:" prefix.
+func isGlobPattern(pattern string) bool {
+	if !strings.Contains(pattern, "*") {
+		return false
+	}
+	if strings.Contains(pattern, ".*") || strings.Contains(pattern, ".+") || strings.ContainsAny(pattern, `\^$()|+`) {
+		return false
+	}
+	return !regexQuantifier.MatchString(pattern)
+}
+
+// globToRegex translates a glob into an anchored regular expression.
+// * and ? stay within a path segment, ** spans segments (**/ matches zero
+// or more directories), [abc], [a-z] and [!abc] are character classes and
+// {a,b} are alternatives, which may nest.
+func globToRegex(glob string) (string, error) {
+	g := &globParser{glob: glob}
+	expr, err := g.parse(false)
+	if err != nil {
+		return "", err
+	}
+	if g.pos < len(glob) {
+		return "", fmt.Errorf("unexpected '%c' in glob", glob[g.pos])
+	}
+	return "^" + expr + "$", nil
+}
+
+type globParser struct {
+	glob string
+	pos  int
+}
+
+// parse translates up to the end of the glob, or up to the , or } ending
+// an alternative when inBrace is set
+func (g *globParser) parse(inBrace bool) (string, error) {
+	var b strings.Builder
+	for g.pos < len(g.glob) {
+		c := g.glob[g.pos]
+		switch c {
+		case '\\':
+			if g.pos+1 == len(g.glob) {
+				return "", fmt.Errorf("trailing '\\' in glob")
+			}
+			b.WriteString(regexp.QuoteMeta(g.glob[g.pos+1 : g.pos+2]))
+			g.pos += 2
+
+		case '*':
+			start := g.pos
+			for g.pos < len(g.glob) && g.glob[g.pos] == '*' {
+				g.pos++
+			}
+			atSegmentStart := start == 0 || g.glob[start-1] == '/'
+			atSegmentEnd := g.pos == len(g.glob) || g.glob[g.pos] == '/'
+			switch {
+			case g.pos-start < 2 || !atSegmentStart || !atSegmentEnd:
+				b.WriteString("[^/]*")
+			case g.pos < len(g.glob):
+				// **/ matches zero or more directories
+				b.WriteString("(?:.*/)?")
+				g.pos++
+			default:
+				b.WriteString(".*")
+			}
+
+		case '?':
+			b.WriteString("[^/]")
+			g.pos++
+
+		case '[':
+			class, err := g.parseClass()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(class)
+
+		case '{':
+			g.pos++
+			var alternatives []string
+			for {
+				alternative, err := g.parse(true)
+				if err != nil {
+					return "", err
+				}
+				alternatives = append(alternatives, alternative)
+				if g.pos == len(g.glob) {
+					return "", fmt.Errorf("unclosed '{' in glob")
+				}
+				g.pos++
+				if g.glob[g.pos-1] == '}' {
+					break
+				}
+			}
+			b.WriteString("(?:" + strings.Join(alternatives, "|") + ")")
+
+		case ',', '}':
+			if inBrace {
+				return b.String(), nil
+			}
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			g.pos++
+
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			g.pos++
+		}
+	}
+	return b.String(), nil
+}
+
+// parseClass translates a [...] character class. A leading ! or ^ negates
+// it and a ] right after the opening bracket is a literal.
+func (g *globParser) parseClass() (string, error) {
+	var b strings.Builder
+	b.WriteString("[")
+	i := g.pos + 1
+	if i < len(g.glob) && (g.glob[i] == '!' || g.glob[i] == '^') {
+		b.WriteString("^/")
+		i++
+	}
+	for first := true; ; first = false {
+		if i >= len(g.glob) {
+			return "", fmt.Errorf("unclosed '[' in glob")
+		}
+		c := g.glob[i]
+		if c == ']' && !first {
+			break
+		}
+		switch {
+		case c == '\\' && i+1 < len(g.glob):
+			i++
+			b.WriteString(quoteClassChar(g.glob[i]))
+		case c == '\\' || c == '[' || c == ']' || c == '^':
+			b.WriteString(`\` + string(c))
+		default:
+			b.WriteByte(c)
+		}
+		i++
+	}
+	b.WriteString("]")
+	g.pos = i + 1
+	return b.String(), nil
+}
+
+// quoteClassChar escapes a literal character inside a regex class
+func quoteClassChar(c byte) string {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80 {
+		return string(c)
+	}
+	return `\` + string(c)
+}
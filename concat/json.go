@@ -0,0 +1,102 @@
+package concat
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// JSONRenderer writes one JSON object: the document metadata and a files
+// array. The estimated token total comes after the files, so the files
+// are streamed rather than held in memory.
+type JSONRenderer struct{}
+
+// JSONLRenderer writes one JSON object per file and line, for streaming
+// into scripts such as embedding or eval jobs.
+type JSONLRenderer struct{}
+
+// jsonFile is a file in the JSON formats. Size, SHA256 and Tokens describe
+// Content, the file as written.
+type jsonFile struct {
+	Path     string     `json:"path"`
+	Size     int        `json:"size"`
+	Language string     `json:"language,omitempty"`
+	SHA256   string     `json:"sha256"`
+	Tokens   int        `json:"tokens"`
+	Encoding string     `json:"encoding,omitempty"`
+	Oversize SizePolicy `json:"oversize,omitempty"`
+	Content  string     `json:"content"`
+}
+
+func newJSONFile(doc *Document, file File, content []byte) jsonFile {
+	sum := sha256.Sum256(content)
+	return jsonFile{
+		Path:     doc.Path(file),
+		Size:     len(content),
+		Language: Language(file.Path, content),
+		SHA256:   hex.EncodeToString(sum[:]),
+		Tokens:   EstimateTokens(string(content)),
+		Encoding: file.Encoding,
+		Oversize: file.Oversize,
+		Content:  string(content),
+	}
+}
+
+// Render implements Renderer.
+func (JSONRenderer) Render(w io.Writer, doc *Document) error {
+	var b bytes.Buffer
+	b.WriteString("{\n")
+	fmt.Fprintf(&b, "\"generated\": %s,\n", marshalJSON(doc.Generated.Format(time.RFC3339)))
+	fmt.Fprintf(&b, "\"sources\": %s,\n", marshalJSON(doc.sourceMeta()))
+	fmt.Fprintf(&b, "\"file_count\": %d,\n", len(doc.Files))
+	if tree := doc.tree(); tree != "" {
+		fmt.Fprintf(&b, "\"tree\": %s,\n", marshalJSON(tree))
+	}
+	b.WriteString("\"files\": [")
+	if _, err := b.WriteTo(w); err != nil {
+		return err
+	}
+
+	tokens, first := 0, true
+	err := doc.Each(func(file File, content []byte) error {
+		entry := newJSONFile(doc, file, content)
+		tokens += entry.Tokens
+		b.Reset()
+		if !first {
+			b.WriteString(",")
+		}
+		first = false
+		b.WriteString("\n")
+		b.Write(marshalJSON(entry))
+		_, err := b.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "\n],\n\"estimated_tokens\": %d\n}\n", tokens)
+	return err
+}
+
+// Render implements Renderer.
+func (JSONLRenderer) Render(w io.Writer, doc *Document) error {
+	return doc.Each(func(file File, content []byte) error {
+		line := append(marshalJSON(newJSONFile(doc, file, content)), '\n')
+		_, err := w.Write(line)
+		return err
+	})
+}
+
+// marshalJSON encodes v without escaping <, > and &, which are common in
+// source code. The values encoded here cannot fail.
+func marshalJSON(v any) []byte {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
@@ -0,0 +1,180 @@
+package concat
+
+import (
+	"bytes"
+	"path"
+	"strings"
+)
+
+// languageNames maps file names without a telling extension to a language
+var languageNames = map[string]string{
+	"Dockerfile":     "dockerfile",
+	"Containerfile":  "dockerfile",
+	"Makefile":       "makefile",
+	"GNUmakefile":    "makefile",
+	"CMakeLists.txt": "cmake",
+	"Jenkinsfile":    "groovy",
+	"Gemfile":        "ruby",
+	"Rakefile":       "ruby",
+	"Vagrantfile":    "ruby",
+	"go.mod":         "go-mod",
+	"BUILD":          "starlark",
+	"WORKSPACE":      "starlark",
+}
+
+// languageExtensions maps file extensions to the identifiers Markdown
+// renderers and highlighters use
+var languageExtensions = map[string]string{
+	".go":      "go",
+	".py":      "python",
+	".pyi":     "python",
+	".js":      "javascript",
+	".mjs":     "javascript",
+	".cjs":     "javascript",
+	".jsx":     "jsx",
+	".ts":      "typescript",
+	".mts":     "typescript",
+	".cts":     "typescript",
+	".tsx":     "tsx",
+	".rb":      "ruby",
+	".rs":      "rust",
+	".java":    "java",
+	".kt":      "kotlin",
+	".kts":     "kotlin",
+	".scala":   "scala",
+	".groovy":  "groovy",
+	".gradle":  "groovy",
+	".swift":   "swift",
+	".m":       "objectivec",
+	".c":       "c",
+	".h":       "c",
+	".cc":      "cpp",
+	".cpp":     "cpp",
+	".cxx":     "cpp",
+	".hh":      "cpp",
+	".hpp":     "cpp",
+	".cs":      "csharp",
+	".fs":      "fsharp",
+	".php":     "php",
+	".pl":      "perl",
+	".lua":     "lua",
+	".r":       "r",
+	".dart":    "dart",
+	".ex":      "elixir",
+	".exs":     "elixir",
+	".erl":     "erlang",
+	".hs":      "haskell",
+	".ml":      "ocaml",
+	".clj":     "clojure",
+	".zig":     "zig",
+	".nim":     "nim",
+	".sh":      "bash",
+	".bash":    "bash",
+	".zsh":     "zsh",
+	".fish":    "fish",
+	".ps1":     "powershell",
+	".bat":     "batch",
+	".sql":     "sql",
+	".html":    "html",
+	".htm":     "html",
+	".vue":     "vue",
+	".svelte":  "svelte",
+	".css":     "css",
+	".scss":    "scss",
+	".sass":    "sass",
+	".less":    "less",
+	".json":    "json",
+	".jsonc":   "jsonc",
+	".yaml":    "yaml",
+	".yml":     "yaml",
+	".toml":    "toml",
+	".xml":     "xml",
+	".ini":     "ini",
+	".cfg":     "ini",
+	".md":      "markdown",
+	".mdx":     "mdx",
+	".rst":     "rst",
+	".tex":     "latex",
+	".proto":   "protobuf",
+	".graphql": "graphql",
+	".gql":     "graphql",
+	".tf":      "hcl",
+	".hcl":     "hcl",
+	".nix":     "nix",
+	".diff":    "diff",
+	".patch":   "diff",
+	".mk":      "makefile",
+	".cmake":   "cmake",
+	".bzl":     "starlark",
+}
+
+// interpreters maps shebang interpreters, without version numbers, to a
+// language
+var interpreters = map[string]string{
+	"sh":      "sh",
+	"bash":    "bash",
+	"dash":    "sh",
+	"ksh":     "sh",
+	"zsh":     "zsh",
+	"fish":    "fish",
+	"python":  "python",
+	"ruby":    "ruby",
+	"perl":    "perl",
+	"php":     "php",
+	"lua":     "lua",
+	"node":    "javascript",
+	"nodejs":  "javascript",
+	"deno":    "typescript",
+	"ts-node": "typescript",
+	"Rscript": "r",
+	"pwsh":    "powershell",
+}
+
+// Language returns the language identifier for a file, such as "go" or
+// "tsx", from its name, its extension or the shebang line of its content.
+// It returns "" when the language is unknown.
+func Language(filePath string, content []byte) string {
+	name := path.Base(filePath)
+	if language, ok := languageNames[name]; ok {
+		return language
+	}
+	if language, ok := languageExtensions[strings.ToLower(path.Ext(name))]; ok {
+		return language
+	}
+	return shebangLanguage(content)
+}
+
+// shebangLanguage reads the interpreter from a #! line, looking through
+// /usr/bin/env and its flags
+func shebangLanguage(content []byte) string {
+	if !bytes.HasPrefix(content, []byte("#!")) {
+		return ""
+	}
+	line, _, _ := bytes.Cut(content[2:], []byte("\n"))
+	fields := strings.Fields(string(line))
+	for len(fields) > 0 {
+		interpreter := path.Base(fields[0])
+		fields = fields[1:]
+		if interpreter == "env" || strings.HasPrefix(interpreter, "-") {
+			continue
+		}
+		return interpreters[strings.TrimRight(interpreter, "0123456789.")]
+	}
+	return ""
+}
+
+// fence returns a code fence of backticks longer than any run of
+// backticks in content, and at least three long, so the content cannot
+// close it early
+func fence(content []byte) string {
+	longest, run := 0, 0
+	for _, c := range content {
+		if c == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
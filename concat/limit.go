@@ -0,0 +1,193 @@
+package concat
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SizePolicy decides what happens to files beyond the size limits.
+type SizePolicy string
+
+const (
+	// SkipOversize leaves oversized files out.
+	SkipOversize SizePolicy = "skip"
+	// TruncateOversize keeps the head and tail of oversized files, with a
+	// marker in between.
+	TruncateOversize SizePolicy = "truncate"
+	// StubOversize replaces oversized files by a line giving their path,
+	// size and line count.
+	StubOversize SizePolicy = "stub"
+)
+
+// SizePolicies lists the accepted size policies.
+var SizePolicies = []SizePolicy{SkipOversize, TruncateOversize, StubOversize}
+
+// ParseSizePolicy validates a size policy name. Empty means SkipOversize.
+func ParseSizePolicy(name string) (SizePolicy, error) {
+	if name == "" {
+		return SkipOversize, nil
+	}
+	for _, policy := range SizePolicies {
+		if string(policy) == name {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown size policy %q (expected one of %v)", name, SizePolicies)
+}
+
+// ParseSize parses a size such as "512", "200KB", "1.5MB" or "2G". Units
+// are powers of 1024.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	upper := strings.ToUpper(s)
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+		{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1},
+	} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(upper, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// applyLimits enforces the per-file and total size limits on the included
+// files, in order, according to policy
+func (s *Selection) applyLimits(maxFile, maxTotal int64, policy SizePolicy) {
+	var kept []File
+	var total int64
+	for _, file := range s.Included {
+		limit, rule := file.Size, Rule("")
+		if maxFile > 0 && limit > maxFile {
+			limit, rule = maxFile, RuleFileSize
+		}
+		if maxTotal > 0 && total+limit > maxTotal {
+			limit, rule = max(maxTotal-total, 0), RuleTotalSize
+		}
+
+		if rule != "" {
+			switch {
+			case policy == StubOversize:
+				file.Oversize = StubOversize
+				limit = 0
+				s.Reasons[file.Path] = Reason{Rule: RuleStubbed}
+			case policy == TruncateOversize && limit > 0:
+				file.Oversize = TruncateOversize
+				file.Limit = limit
+				s.Reasons[file.Path] = Reason{Rule: RuleTruncated}
+			default:
+				s.Excluded = append(s.Excluded, file)
+				s.Reasons[file.Path] = Reason{Rule: rule}
+				continue
+			}
+		}
+		total += limit
+		kept = append(kept, file)
+	}
+	s.Included = kept
+}
+
+// Oversized returns the files affected by the size limits: included files
+// that are truncated or stubbed, and the files that were skipped.
+func (s *Selection) Oversized() []File {
+	var files []File
+	for _, file := range s.Included {
+		if file.Oversize != "" {
+			files = append(files, file)
+		}
+	}
+	for _, file := range s.Excluded {
+		if rule := s.Reasons[file.Path].Rule; rule == RuleFileSize || rule == RuleTotalSize {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// readFile returns the content of file as it is written: transcoded to
+// UTF-8 and with its size policy applied. Scan picks the encoding from the
+// head of the file, so a file that only leaves ASCII after its head has its
+// encoding detected here, from the whole content, and recorded in file.
+func readFile(fsys fs.FS, file *File) ([]byte, error) {
+	content, err := fs.ReadFile(fsys, file.Path)
+	if err != nil {
+		return nil, err
+	}
+	if file.Encoding == "" && !utf8.Valid(content) {
+		if encoding, text := detectEncoding(content); text {
+			file.Encoding = encoding
+		}
+	}
+	if content, err = transcode(content, file.Encoding); err != nil {
+		return nil, fmt.Errorf("failed to transcode from %s: %w", file.Encoding, err)
+	}
+	switch file.Oversize {
+	case TruncateOversize:
+		return truncateContent(content, file.Limit), nil
+	case StubOversize:
+		lines := bytes.Count(content, []byte("\n"))
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			lines++
+		}
+		return []byte(fmt.Sprintf("[omitted: %s is %s, %d lines, over the size limit]\n", file.Path, FormatSize(int64(len(content))), lines)), nil
+	}
+	return content, nil
+}
+
+// truncateContent keeps about limit bytes of content, half from the head
+// and half from the tail, cut at line boundaries
+func truncateContent(content []byte, limit int64) []byte {
+	if int64(len(content)) <= limit {
+		return content
+	}
+	head := content[:limit/2]
+	if i := bytes.LastIndexByte(head, '\n'); i >= 0 {
+		head = head[:i+1]
+	}
+	tail := content[int64(len(content))-limit/2:]
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+
+	var b bytes.Buffer
+	b.Write(head)
+	if len(head) > 0 && head[len(head)-1] != '\n' {
+		b.WriteByte('\n')
+	}
+	omitted := len(content) - len(head) - len(tail)
+	fmt.Fprintf(&b, "... [truncated: %s of %s omitted] ...\n", FormatSize(int64(omitted)), FormatSize(int64(len(content))))
+	b.Write(tail)
+	return b.Bytes()
+}
+
+// FormatSize formats a byte count for people.
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
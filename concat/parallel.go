@@ -0,0 +1,164 @@
+package concat
+
+import (
+	"context"
+	"io/fs"
+	"runtime"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// DefaultReadAhead is the default of Options.ReadAhead.
+const DefaultReadAhead = 64 << 20
+
+// Stage is the step of a run a ProgressEvent reports on.
+type Stage string
+
+const (
+	// StageClassify is Scan sniffing the content of files that pass the
+	// name based rules.
+	StageClassify Stage = "classify"
+	// StageBudget is Scan reading files to fit them into Options.MaxTokens.
+	StageBudget Stage = "budget"
+	// StageRead is a renderer reading files for the output.
+	StageRead Stage = "read"
+)
+
+// ProgressEvent reports that a worker finished a file.
+type ProgressEvent struct {
+	Stage Stage
+	// Path is the path of the file in its tree.
+	Path string
+	// Done counts the files of the stage finished so far, out of Total.
+	Done  int
+	Total int
+}
+
+func (o Options) parallelism() int {
+	if o.Parallelism > 0 {
+		return o.Parallelism
+	}
+	return runtime.NumCPU()
+}
+
+func (o Options) readAhead() int64 {
+	if o.ReadAhead > 0 {
+		return o.ReadAhead
+	}
+	return DefaultReadAhead
+}
+
+// progress counts the files of a stage and serialises Options.Progress
+type progress struct {
+	mu    sync.Mutex
+	fn    func(ProgressEvent)
+	stage Stage
+	done  int
+	total int
+}
+
+func newProgress(opts Options, stage Stage, total int) *progress {
+	return &progress{fn: opts.Progress, stage: stage, total: total}
+}
+
+func (p *progress) finished(path string) {
+	if p.fn == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.fn(ProgressEvent{Stage: p.stage, Path: path, Done: p.done, Total: p.total})
+}
+
+// forEach calls fn for every index below n from a pool of workers and
+// waits for them
+func forEach(n, workers int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(n, workers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := range n {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// readResult is a file read by readFiles, with the encoding readFile
+// detected
+type readResult struct {
+	file    File
+	content []byte
+	err     error
+}
+
+// readFiles reads files with a pool of workers and calls fn with each one's
+// content, or the error reading it, in the order of files. The file fn
+// gets carries the encoding readFile detected. Reads are started in order
+// and only while the content waiting for fn stays within opts.ReadAhead,
+// so a slow fn holds back the workers rather than letting content pile up. When fn returns an error, reading stops and readFiles
+// returns it.
+func readFiles(files []File, fsFor func(File) fs.FS, opts Options, stage Stage, fn func(file File, content []byte, err error) error) error {
+	budget := opts.readAhead()
+	weight := func(file File) int64 { return min(max(file.Size, 1), budget) }
+	reserved := semaphore.NewWeighted(budget)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	// Stop handing out files and let the reads under way finish before
+	// returning, so no worker outlives the call
+	defer wg.Wait()
+	defer cancel()
+
+	// Each result has room for its one value, so workers never wait on fn
+	results := make([]chan readResult, len(files))
+	for i := range results {
+		results[i] = make(chan readResult, 1)
+	}
+	indexes := make(chan int)
+	go func() {
+		defer close(indexes)
+		for i, file := range files {
+			if reserved.Acquire(ctx, weight(file)) != nil {
+				return
+			}
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	p := newProgress(opts, stage, len(files))
+	for range min(len(files), opts.parallelism()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				file := files[i]
+				content, err := readFile(fsFor(file), &file)
+				results[i] <- readResult{file, content, err}
+				p.finished(files[i].Path)
+			}
+		}()
+	}
+
+	for i, file := range files {
+		r := <-results[i]
+		err := fn(r.file, r.content, r.err)
+		reserved.Release(weight(file))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
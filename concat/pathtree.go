@@ -0,0 +1,74 @@
+package concat
+
+import (
+	"sort"
+	"strings"
+)
+
+// PathNode is a directory or file of the tree BuildPathTree builds.
+type PathNode struct {
+	Name string
+	// Children holds the entries of a directory, directories first and
+	// each group by name. It is nil for files.
+	Children []*PathNode
+}
+
+// IsDir reports whether the node is a directory.
+func (n *PathNode) IsDir() bool {
+	return n.Children != nil
+}
+
+// BuildPathTree arranges slash separated file paths into a tree. The root
+// node is unnamed.
+func BuildPathTree(paths []string) *PathNode {
+	root := &PathNode{Children: []*PathNode{}}
+	dirs := map[string]*PathNode{"": root}
+	for _, p := range paths {
+		parent, dir := root, ""
+		parts := strings.Split(p, "/")
+		for _, part := range parts[:len(parts)-1] {
+			dir += part + "/"
+			node, ok := dirs[dir]
+			if !ok {
+				node = &PathNode{Name: part, Children: []*PathNode{}}
+				dirs[dir] = node
+				parent.Children = append(parent.Children, node)
+			}
+			parent = node
+		}
+		parent.Children = append(parent.Children, &PathNode{Name: parts[len(parts)-1]})
+	}
+	for _, dir := range dirs {
+		sort.SliceStable(dir.Children, func(i, j int) bool {
+			a, b := dir.Children[i], dir.Children[j]
+			if a.IsDir() != b.IsDir() {
+				return a.IsDir()
+			}
+			return a.Name < b.Name
+		})
+	}
+	return root
+}
+
+// RenderPathTree draws slash separated file paths as an ASCII tree, one
+// entry per line, with directories ending in "/".
+func RenderPathTree(paths []string) string {
+	var b strings.Builder
+	var walk func(n *PathNode, prefix string)
+	walk = func(n *PathNode, prefix string) {
+		for i, child := range n.Children {
+			branch, indent := "├── ", "│   "
+			if i == len(n.Children)-1 {
+				branch, indent = "└── ", "    "
+			}
+			if !child.IsDir() {
+				b.WriteString(prefix + branch + child.Name + "\n")
+				continue
+			}
+			b.WriteString(prefix + branch + child.Name + "/\n")
+			walk(child, prefix+indent)
+		}
+	}
+	walk(BuildPathTree(paths), "")
+	return b.String()
+}
@@ -0,0 +1,152 @@
+package concat
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// Renderer writes a Document in a particular output format.
+type Renderer interface {
+	Render(w io.Writer, doc *Document) error
+}
+
+// Formats lists the output formats NewRenderer accepts.
+var Formats = []string{"markdown", "xml", "json", "jsonl"}
+
+// NewRenderer returns the Renderer for an output format.
+func NewRenderer(format string) (Renderer, error) {
+	switch format {
+	case "", "markdown":
+		return MarkdownRenderer{}, nil
+	case "xml":
+		return XMLRenderer{}, nil
+	case "json":
+		return JSONRenderer{}, nil
+	case "jsonl":
+		return JSONLRenderer{}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q (expected one of %v)", format, Formats)
+}
+
+// Document is the input handed to a Renderer.
+type Document struct {
+	// SourceInfo describes the source of a single-source document and is
+	// empty when there are several.
+	SourceInfo
+	// Sources lists every source in order. They are labelled when there
+	// is more than one.
+	Sources   []SourceInfo
+	Generated time.Time
+	Files     []File
+
+	fs   []fs.FS
+	opts Options
+}
+
+// Path returns the path under which file is shown: its path in the tree,
+// prefixed with its source's label in multi-source documents.
+func (d *Document) Path(file File) string {
+	if len(d.Sources) > 1 {
+		return d.Sources[file.Source].Label + "/" + file.Path
+	}
+	return file.Path
+}
+
+// Each calls fn with the content of every file, in order. Files are read
+// ahead by a pool of workers, see Options.Parallelism and
+// Options.ReadAhead. Files that cannot be read are reported as a warning
+// and skipped.
+func (d *Document) Each(fn func(file File, content []byte) error) error {
+	fsFor := func(file File) fs.FS { return d.fs[file.Source] }
+	return readFiles(d.Files, fsFor, d.opts, StageRead, func(file File, content []byte, err error) error {
+		if err != nil {
+			d.opts.notify("warning", fmt.Sprintf("Failed to read file %s: %v", d.Path(file), err))
+			return nil
+		}
+		return fn(file, content)
+	})
+}
+
+// sourceMeta is the metadata of a source in structured formats
+type sourceMeta struct {
+	Label      string `xml:"label,attr,omitempty" json:"label,omitempty"`
+	Repository string `xml:"name,omitempty" json:"repository,omitempty"`
+	URL        string `xml:"url,omitempty" json:"url,omitempty"`
+	Path       string `xml:"path,omitempty" json:"path,omitempty"`
+	Archive    string `xml:"archive,omitempty" json:"archive,omitempty"`
+	Ref        string `xml:"ref,omitempty" json:"ref,omitempty"`
+	Commit     string `xml:"commit,omitempty" json:"commit,omitempty"`
+	Subdir     string `xml:"subdir,omitempty" json:"subdir,omitempty"`
+}
+
+// sourceMeta returns the metadata of every source. Labels are only set in
+// multi-source documents.
+func (d *Document) sourceMeta() []sourceMeta {
+	var sources []sourceMeta
+	for _, source := range d.Sources {
+		if len(d.Sources) == 1 {
+			source.Label = ""
+		}
+		sources = append(sources, sourceMeta{
+			Label:      source.Label,
+			Repository: source.Repository,
+			URL:        source.URL,
+			Path:       source.Path,
+			Archive:    source.Archive,
+			Ref:        source.Ref,
+			Commit:     source.Commit,
+			Subdir:     source.Subdir,
+		})
+	}
+	return sources
+}
+
+// tree is the directory tree of the files with Options.Tree, else ""
+func (d *Document) tree() string {
+	if !d.opts.Tree {
+		return ""
+	}
+	paths := make([]string, len(d.Files))
+	for i, file := range d.Files {
+		paths[i] = d.Path(file)
+	}
+	return RenderPathTree(paths)
+}
+
+// EstimateTokens estimates the tokens of all file contents, for renderers
+// that state the total before the files. Unreadable files count as empty.
+func (d *Document) EstimateTokens() int {
+	tokens := 0
+	opts := d.opts
+	opts.Progress = nil
+	fsFor := func(file File) fs.FS { return d.fs[file.Source] }
+	readFiles(d.Files, fsFor, opts, StageRead, func(file File, content []byte, err error) error {
+		if err == nil {
+			tokens += EstimateTokens(string(content))
+		}
+		return nil
+	})
+	return tokens
+}
+
+// MarkdownRenderer writes every file under a "# File:" header inside a
+// fenced code block tagged with its Language. The fence is longer than any
+// backtick run in the file, so embedded code blocks cannot close it. Files
+// transcoded to UTF-8 get an "# Encoding:" line naming their original
+// encoding. It executes DefaultTemplate.
+type MarkdownRenderer struct{}
+
+var markdownTemplate = func() *TemplateRenderer {
+	r, err := NewTemplateRenderer("markdown", DefaultTemplate)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}()
+
+// Render implements Renderer.
+func (MarkdownRenderer) Render(w io.Writer, doc *Document) error {
+	return markdownTemplate.Render(w, doc)
+}
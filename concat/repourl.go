@@ -0,0 +1,252 @@
+package concat
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// RepoURL is a parsed repository location. It understands HTTPS and SSH
+// URLs for any host, SCP-style SSH (git@host:org/repo.git), nested groups
+// (gitlab.com/a/b/c/repo), browser URLs that point into a repository
+// (/tree/<ref>/<dir>, /blob/<ref>/<file>) and local repositories
+// (file:///srv/git/repo.git or a plain path).
+type RepoURL struct {
+	// Raw is the URL as given, with any embedded credentials removed.
+	Raw string
+	// CloneURL is what git clone receives.
+	CloneURL string
+	// Host is empty for local repositories.
+	Host string
+	// Owner is the user, organisation or group path. It may contain
+	// slashes for nested groups and is empty for local repositories.
+	Owner string
+	// Name is the repository name without a .git suffix.
+	Name string
+	// Ref and Subdir come from browser URLs such as /tree/main/pkg.
+	// Subdir may name a directory or a single file.
+	Ref    string
+	Subdir string
+
+	// webPath holds the segments after /tree/ or /blob/. Ref names with
+	// slashes make the split between Ref and Subdir ambiguous until the
+	// remote's refs are known, see resolveWebRef.
+	webPath []string
+
+	// username and token come from credentials embedded in an HTTPS
+	// URL. They are moved out of CloneURL and handed to git separately.
+	username string
+	token    string
+}
+
+// scpPattern matches SCP-style SSH locations such as git@github.com:org/repo.git
+var scpPattern = regexp.MustCompile(`^(?:[^@/:]+@)?([^@/:]+):(.+)$`)
+
+// ParseRepoURL parses a repository URL or local repository path.
+func ParseRepoURL(raw string) (*RepoURL, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, fmt.Errorf("empty repository URL")
+	}
+
+	u := &RepoURL{Raw: RedactURL(raw)}
+
+	switch {
+	case strings.Contains(raw, "://"):
+		parsed, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository URL %q", u.Raw)
+		}
+		if parsed.Scheme == "file" {
+			u.setLocal(parsed.Path)
+			u.CloneURL = raw
+			break
+		}
+		u.Host = parsed.Hostname()
+		repoPath := u.splitWebPath(strings.Trim(parsed.Path, "/"))
+		if err := u.setRepoPath(repoPath); err != nil {
+			return nil, err
+		}
+		clone := *parsed
+		clone.Path = "/" + repoPath
+		clone.RawQuery = ""
+		clone.Fragment = ""
+		if parsed.User != nil && (parsed.Scheme == "http" || parsed.Scheme == "https") {
+			if password, ok := parsed.User.Password(); ok {
+				u.username, u.token = parsed.User.Username(), password
+			} else {
+				u.token = parsed.User.Username()
+			}
+			clone.User = nil
+		}
+		u.CloneURL = clone.String()
+
+	case scpPattern.MatchString(raw) && !isLocalPath(raw):
+		m := scpPattern.FindStringSubmatch(raw)
+		u.Host = m[1]
+		if err := u.setRepoPath(strings.Trim(m[2], "/")); err != nil {
+			return nil, err
+		}
+		u.CloneURL = raw
+
+	default:
+		u.setLocal(raw)
+		u.CloneURL = raw
+	}
+
+	return u, nil
+}
+
+// Repository returns the host/owner/name identity of a remote repository,
+// or the repository path for local ones.
+func (u *RepoURL) Repository() string {
+	if u.Host == "" {
+		return u.CloneURL
+	}
+	return path.Join(u.Host, u.Owner, u.Name)
+}
+
+// scheme returns the lower case URL scheme of the remote: "https", "http",
+// "ssh", "git", or "file" for local and "ssh" for SCP-style locations
+func (u *RepoURL) scheme() string {
+	switch {
+	case u.Host == "":
+		return "file"
+	case !strings.Contains(u.CloneURL, "://"):
+		return "ssh"
+	}
+	scheme, _, _ := strings.Cut(u.CloneURL, "://")
+	return strings.ToLower(scheme)
+}
+
+// cacheID identifies the repository independently of URL spelling, so
+// https://host/org/repo and git@host:org/repo.git share a cache entry.
+func (u *RepoURL) cacheID() string {
+	if u.Host == "" {
+		if abs, err := filepath.Abs(strings.TrimPrefix(u.CloneURL, "file://")); err == nil {
+			return abs
+		}
+		return u.CloneURL
+	}
+	return strings.ToLower(u.Repository())
+}
+
+func (u *RepoURL) setLocal(p string) {
+	u.Name = strings.TrimSuffix(filepath.Base(filepath.Clean(p)), ".git")
+	if u.Name == "" || u.Name == "." || u.Name == "/" {
+		u.Name = "repository"
+	}
+}
+
+func (u *RepoURL) setRepoPath(repoPath string) error {
+	parts := strings.Split(repoPath, "/")
+	if len(parts) < 2 || parts[0] == "" {
+		return fmt.Errorf("repository URL %q must name an owner and a repository", u.Raw)
+	}
+	u.Owner = strings.Join(parts[:len(parts)-1], "/")
+	u.Name = strings.TrimSuffix(parts[len(parts)-1], ".git")
+	return nil
+}
+
+// splitWebPath strips the browser part of a URL path (/tree/<ref>/<dir>
+// and friends), records the ref and subdirectory, and returns the path of
+// the repository itself. Apart from GitLab's "-", a segment only starts
+// the browser part when a ref follows it, and only in the spellings the
+// host uses, so that groups and repositories named "tree" or "src" are not
+// mistaken for one.
+func (u *RepoURL) splitWebPath(p string) string {
+	parts := strings.Split(p, "/")
+
+	for i := 2; i < len(parts); i++ {
+		if rest, ok := u.webPathAt(parts[i:]); ok {
+			if len(rest) > 0 {
+				u.webPath = rest
+				u.Ref = rest[0]
+				u.Subdir = strings.Join(rest[1:], "/")
+			}
+			return strings.Join(parts[:i], "/")
+		}
+		// GitHub and Bitbucket repositories are always owner/name
+		if i == 2 && (u.Host == "github.com" || u.Host == "bitbucket.org") {
+			break
+		}
+	}
+	return p
+}
+
+// webPathAt reports whether parts start the browser part of a URL on u's
+// host, and returns the segments from the ref on, if any:
+//
+//	/-/tree/<ref>/..., /-/blob/<ref>/...   GitLab; other /-/ pages have no ref
+//	/tree/<ref>/..., /blob/<ref>/...       GitHub, Gitea and other hosts
+//	/src/<ref>/...                         Bitbucket
+//	/src/{branch,tag,commit}/<ref>/...     Gitea
+//
+// GitLab nests groups without limit, so only "-", which no group or
+// project can be named, ends the repository path there.
+func (u *RepoURL) webPathAt(parts []string) ([]string, bool) {
+	gitlab := u.Host == "gitlab.com" || strings.HasPrefix(u.Host, "gitlab.")
+	var rest []string
+	switch {
+	case parts[0] == "-":
+		if len(parts) > 2 && (parts[1] == "tree" || parts[1] == "blob") && parts[2] != "" {
+			return parts[2:], true
+		}
+		return nil, true
+	case gitlab:
+		return nil, false
+	case parts[0] == "tree" || parts[0] == "blob":
+		if u.Host == "bitbucket.org" {
+			return nil, false
+		}
+		rest = parts[1:]
+	case parts[0] == "src" && u.Host == "bitbucket.org":
+		rest = parts[1:]
+	case parts[0] == "src" && len(parts) > 1 && (parts[1] == "branch" || parts[1] == "tag" || parts[1] == "commit"):
+		rest = parts[2:]
+	default:
+		return nil, false
+	}
+	if len(rest) == 0 || rest[0] == "" {
+		return nil, false
+	}
+	return rest, true
+}
+
+// resolveWebRef settles the Ref/Subdir split of a browser URL whose ref
+// may contain slashes (/tree/feature/login/pkg) by picking the longest
+// prefix that names a branch or tag on the remote. Without a match the
+// first segment is kept as the ref, which covers commit SHAs.
+func (u *RepoURL) resolveWebRef(remoteRefs map[string]bool) {
+	for i := len(u.webPath); i > 1; i-- {
+		candidate := strings.Join(u.webPath[:i], "/")
+		if remoteRefs["refs/heads/"+candidate] || remoteRefs["refs/tags/"+candidate] {
+			u.Ref = candidate
+			u.Subdir = strings.Join(u.webPath[i:], "/")
+			return
+		}
+	}
+}
+
+// inScope reports whether the slash separated path lies within subdir,
+// which may name a directory or a single file. An empty subdir is the root.
+func inScope(relativePath, subdir string) bool {
+	return subdir == "" || relativePath == subdir || strings.HasPrefix(relativePath, subdir+"/")
+}
+
+// isLocalPath reports whether raw looks like a file system path rather
+// than an SCP-style location.
+func isLocalPath(raw string) bool {
+	if strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, ".") {
+		return true
+	}
+	// Windows drive letters (C:\repo) look like SCP hosts
+	if len(raw) >= 2 && raw[1] == ':' && len(strings.SplitN(raw, ":", 2)[0]) == 1 {
+		return true
+	}
+	slash := strings.Index(raw, "/")
+	return slash >= 0 && slash < strings.Index(raw, ":")
+}
@@ -0,0 +1,94 @@
+package concat
+
+import "testing"
+
+func TestParseRepoURL(t *testing.T) {
+	tests := []struct {
+		raw      string
+		cloneURL string
+		owner    string
+		name     string
+		ref      string
+		subdir   string
+	}{
+		// GitHub
+		{"https://github.com/acme/app", "https://github.com/acme/app", "acme", "app", "", ""},
+		{"https://github.com/acme/app.git", "https://github.com/acme/app.git", "acme", "app", "", ""},
+		{"https://github.com/acme/app/tree/main/pkg/server", "https://github.com/acme/app", "acme", "app", "main", "pkg/server"},
+		{"https://github.com/acme/app/blob/v1.2.0/cmd/main.go", "https://github.com/acme/app", "acme", "app", "v1.2.0", "cmd/main.go"},
+		{"https://github.com/acme/app/tree/main/", "https://github.com/acme/app", "acme", "app", "main", ""},
+		{"git@github.com:acme/app.git", "git@github.com:acme/app.git", "acme", "app", "", ""},
+
+		// GitLab nested groups, including groups and repositories named
+		// like browser path segments
+		{"https://gitlab.com/group/subgroup/repo", "https://gitlab.com/group/subgroup/repo", "group/subgroup", "repo", "", ""},
+		{"https://gitlab.com/acme/platform/src", "https://gitlab.com/acme/platform/src", "acme/platform", "src", "", ""},
+		{"https://gitlab.com/acme/platform/tree", "https://gitlab.com/acme/platform/tree", "acme/platform", "tree", "", ""},
+		{"https://gitlab.com/acme/platform/blob", "https://gitlab.com/acme/platform/blob", "acme/platform", "blob", "", ""},
+		{"https://gitlab.com/acme/tree/blob/repo", "https://gitlab.com/acme/tree/blob/repo", "acme/tree/blob", "repo", "", ""},
+		{"https://gitlab.com/acme/src/main/repo", "https://gitlab.com/acme/src/main/repo", "acme/src/main", "repo", "", ""},
+		{"https://gitlab.com/acme/tree/repo/-/tree/main/docs", "https://gitlab.com/acme/tree/repo", "acme/tree", "repo", "main", "docs"},
+		{"https://gitlab.com/acme/src/repo/-/blob/v2/README.md", "https://gitlab.com/acme/src/repo", "acme/src", "repo", "v2", "README.md"},
+		{"https://gitlab.com/acme/platform/-/commits/main", "https://gitlab.com/acme/platform", "acme", "platform", "", ""},
+		{"https://gitlab.com/acme/platform/-/tree/", "https://gitlab.com/acme/platform", "acme", "platform", "", ""},
+		{"https://gitlab.example.com/a/b/c/repo/-/tree/dev", "https://gitlab.example.com/a/b/c/repo", "a/b/c", "repo", "dev", ""},
+
+		// Bitbucket
+		{"https://bitbucket.org/team/repo/src/main/lib", "https://bitbucket.org/team/repo", "team", "repo", "main", "lib"},
+		{"https://bitbucket.org/team/repo/src", "https://bitbucket.org/team/repo/src", "team/repo", "src", "", ""},
+
+		// Gitea and other hosts
+		{"https://gitea.example.com/org/repo/src/branch/main/pkg", "https://gitea.example.com/org/repo", "org", "repo", "main", "pkg"},
+		{"https://gitea.example.com/org/repo/src/commit/3f2c1e9", "https://gitea.example.com/org/repo", "org", "repo", "3f2c1e9", ""},
+		{"https://git.example.com/org/repo/tree/main/pkg", "https://git.example.com/org/repo", "org", "repo", "main", "pkg"},
+		{"https://git.example.com/org/sub/src/repo", "https://git.example.com/org/sub/src/repo", "org/sub/src", "repo", "", ""},
+		{"https://git.example.com/org/repo/src/main", "https://git.example.com/org/repo/src/main", "org/repo/src", "main", "", ""},
+		{"https://git.example.com/org/repo/tree", "https://git.example.com/org/repo/tree", "org/repo", "tree", "", ""},
+
+		// Local repositories
+		{"file:///srv/git/repo.git", "file:///srv/git/repo.git", "", "repo", "", ""},
+		{"/srv/git/repo.git", "/srv/git/repo.git", "", "repo", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			u, err := ParseRepoURL(tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.CloneURL != tt.cloneURL || u.Owner != tt.owner || u.Name != tt.name || u.Ref != tt.ref || u.Subdir != tt.subdir {
+				t.Errorf("got clone %q owner %q name %q ref %q subdir %q, want %q %q %q %q %q",
+					u.CloneURL, u.Owner, u.Name, u.Ref, u.Subdir,
+					tt.cloneURL, tt.owner, tt.name, tt.ref, tt.subdir)
+			}
+		})
+	}
+}
+
+func TestResolveWebRef(t *testing.T) {
+	refs := map[string]bool{
+		"refs/heads/main":          true,
+		"refs/heads/feature/login": true,
+		"refs/tags/v1.2.0":         true,
+	}
+	tests := []struct {
+		raw    string
+		ref    string
+		subdir string
+	}{
+		{"https://github.com/acme/app/tree/feature/login/pkg", "feature/login", "pkg"},
+		{"https://github.com/acme/app/tree/main/pkg/server", "main", "pkg/server"},
+		{"https://github.com/acme/app/tree/3f2c1e9/pkg", "3f2c1e9", "pkg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			u, err := ParseRepoURL(tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u.resolveWebRef(refs)
+			if u.Ref != tt.ref || u.Subdir != tt.subdir {
+				t.Errorf("got ref %q subdir %q, want %q %q", u.Ref, u.Subdir, tt.ref, tt.subdir)
+			}
+		})
+	}
+}
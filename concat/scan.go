@@ -0,0 +1,338 @@
+package concat
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Selection is the outcome of scanning a Tree.
+type Selection struct {
+	Included []File
+	Excluded []File
+
+	// Reasons records why each file was included or excluded. Directories
+	// that were not walked at all are recorded with a trailing slash.
+	Reasons map[string]Reason
+
+	// Config is the project configuration that was applied.
+	Config *ProjectConfig
+}
+
+// ReasonCount is how many excluded files a reason accounts for.
+type ReasonCount struct {
+	Reason string
+	Count  int
+}
+
+// ExclusionCounts returns how many excluded files each reason accounts
+// for, most frequent first. Directories that were not walked count once
+// per directory.
+func (s *Selection) ExclusionCounts() []ReasonCount {
+	return ExclusionCounts(s)
+}
+
+// ExclusionCounts adds up the exclusion reasons of several selections,
+// most frequent first and then by reason.
+func ExclusionCounts(selections ...*Selection) []ReasonCount {
+	counts := make(map[string]int)
+	for _, s := range selections {
+		for _, file := range s.Excluded {
+			counts[s.Reasons[file.Path].String()]++
+		}
+		for p, reason := range s.Reasons {
+			if strings.HasSuffix(p, "/") {
+				counts[reason.String()+" (whole directory)"]++
+			}
+		}
+	}
+
+	sorted := make([]ReasonCount, 0, len(counts))
+	for reason, count := range counts {
+		sorted = append(sorted, ReasonCount{reason, count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Reason < sorted[j].Reason
+	})
+	return sorted
+}
+
+// Scan walks tree and splits its files into included and excluded sets.
+//
+// Unless disabled, the project configuration at the root of the tree is
+// merged underneath opts. The content of files is sniffed by
+// opts.Parallelism workers, which does not change the result.
+func Scan(tree *Tree, opts Options) (*Selection, error) {
+	s, err := newScanner(tree, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sel := &Selection{Reasons: make(map[string]Reason), Config: s.config}
+	walker := Walker{
+		FS: tree.FS,
+		SkipDir: func(dir string) bool {
+			if !inScope(dir, tree.Subdir) && !strings.HasPrefix(tree.Subdir, dir+"/") {
+				return true
+			}
+			skip, reason := s.skipDir(dir)
+			if skip {
+				sel.Reasons[dir+"/"] = reason
+			}
+			return skip
+		},
+	}
+	// The walk applies the name based rules, which share the caches of the
+	// ignore files. Sniffing content is left to a pool of workers.
+	type decision struct {
+		file     File
+		selected bool
+		reason   Reason
+	}
+	var decisions []decision
+	var sniffed []int
+	err = walker.Walk(func(file File) error {
+		if !inScope(file.Path, tree.Subdir) {
+			return nil
+		}
+		selected, reason := s.decideName(file.Path)
+		if selected {
+			sniffed = append(sniffed, len(decisions))
+		}
+		decisions = append(decisions, decision{file, selected, reason})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if hinter, ok := tree.FS.(interface{ willRead([]string) }); ok {
+		names := make([]string, len(sniffed))
+		for i, index := range sniffed {
+			names[i] = decisions[index].file.Path
+		}
+		hinter.willRead(names)
+	}
+
+	p := newProgress(s.opts, StageClassify, len(sniffed))
+	forEach(len(sniffed), s.opts.parallelism(), func(i int) {
+		d := &decisions[sniffed[i]]
+		if excluded, why := s.sniff(&d.file); excluded {
+			d.selected, d.reason = false, why
+		}
+		p.finished(d.file.Path)
+	})
+
+	// Files are kept in walk order, whichever worker finished first
+	for _, d := range decisions {
+		sel.Reasons[d.file.Path] = d.reason
+		if d.selected {
+			sel.Included = append(sel.Included, d.file)
+		} else {
+			sel.Excluded = append(sel.Excluded, d.file)
+		}
+	}
+
+	sortFiles(sel.Included, s.opts.Order)
+	if s.opts.MaxFileSize > 0 || s.opts.MaxTotalSize > 0 {
+		sel.applyLimits(s.opts.MaxFileSize, s.opts.MaxTotalSize, s.opts.SizePolicy)
+	}
+	if s.opts.MaxTokens > 0 {
+		sel.applyBudget(tree.FS, s.opts)
+	}
+
+	return sel, nil
+}
+
+// Explain reports whether the file at the slash separated relative path
+// would be included by Scan, and why. Unlike Scan it also answers for
+// files inside directories that Scan would not walk.
+func Explain(tree *Tree, opts Options, relativePath string) (bool, Reason, error) {
+	relativePath = path.Clean(strings.TrimPrefix(relativePath, "/"))
+	info, err := fs.Stat(tree.FS, relativePath)
+	if err != nil {
+		return false, Reason{}, fmt.Errorf("no such file in %s: %s", tree.Name, relativePath)
+	}
+	if info.IsDir() {
+		return false, Reason{}, fmt.Errorf("%s is a directory", relativePath)
+	}
+	if !inScope(relativePath, tree.Subdir) {
+		return false, Reason{Rule: RuleOutOfScope}, nil
+	}
+
+	s, err := newScanner(tree, opts)
+	if err != nil {
+		return false, Reason{}, err
+	}
+	selected, reason := s.decide(&File{Path: relativePath, Size: info.Size()})
+	if !selected || (s.opts.MaxTokens == 0 && s.opts.MaxFileSize == 0 && s.opts.MaxTotalSize == 0) {
+		return selected, reason, nil
+	}
+
+	// Whether a file fits the budgets depends on the files before it
+	sel, err := Scan(tree, opts)
+	if err != nil {
+		return false, Reason{}, err
+	}
+	for _, file := range sel.Included {
+		if file.Path == relativePath {
+			return true, sel.Reasons[relativePath], nil
+		}
+	}
+	return false, sel.Reasons[relativePath], nil
+}
+
+// scanner holds the rules deciding which files of a tree are selected
+type scanner struct {
+	tree       *Tree
+	opts       Options
+	config     *ProjectConfig
+	matcher    *Matcher
+	gitignore  *gitignore
+	ignoreFile *gitignore
+	classifier *classifier
+}
+
+func newScanner(tree *Tree, opts Options) (*scanner, error) {
+	s := &scanner{tree: tree, config: &ProjectConfig{}}
+	if !opts.NoProjectConfig {
+		var err error
+		if s.config, err = LoadProjectConfig(tree.FS); err != nil {
+			return nil, err
+		}
+		opts = s.config.merge(opts)
+	}
+	if opts.Order != "" && !validOrder(opts.Order) {
+		return nil, fmt.Errorf("unknown order %q (expected one of %v)", opts.Order, Orders)
+	}
+	if _, err := ParseSizePolicy(string(opts.SizePolicy)); err != nil {
+		return nil, err
+	}
+	for _, category := range opts.Allow {
+		if _, err := ParseCategory(string(category)); err != nil {
+			return nil, err
+		}
+	}
+	s.opts = opts
+
+	var err error
+	if s.matcher, err = NewMatcher(opts.Include, opts.Exclude); err != nil {
+		return nil, err
+	}
+	if !opts.NoGitignore {
+		s.gitignore = newGitignore(tree.FS)
+	}
+	if s.config.ignore != nil {
+		s.ignoreFile = newIgnoreFile(s.config.ignore)
+	}
+	s.classifier = newClassifier(tree.FS, opts.Allow)
+	return s, nil
+}
+
+// skipDir reports whether a whole directory is excluded
+func (s *scanner) skipDir(dir string) (bool, Reason) {
+	if skip, reason := s.matcher.SkipDir(dir); skip {
+		return true, reason
+	}
+	if s.gitignore != nil {
+		if rule := s.gitignore.matchDir(dir); rule != nil {
+			return true, ignoreReason(RuleGitignore, rule)
+		}
+	}
+	if s.ignoreFile != nil {
+		if rule := s.ignoreFile.matchDir(dir); rule != nil {
+			return true, ignoreReason(RuleIgnoreFile, rule)
+		}
+	}
+	return false, Reason{}
+}
+
+// decide applies the patterns, the ignore files, the classifier and text
+// detection to a file, and records the encoding of text files. Content is
+// only sniffed once the name based rules pass.
+func (s *scanner) decide(file *File) (bool, Reason) {
+	selected, reason := s.decideName(file.Path)
+	if !selected {
+		return false, reason
+	}
+	if excluded, why := s.sniff(file); excluded {
+		return false, why
+	}
+	return true, reason
+}
+
+// decideName applies the patterns, the ignore files and the classifier's
+// name rules to a file
+func (s *scanner) decideName(relativePath string) (bool, Reason) {
+	selected, reason := s.matcher.Match(relativePath)
+	if !selected {
+		return false, reason
+	}
+	if s.gitignore != nil {
+		if rule := s.gitignore.match(relativePath); rule != nil {
+			return false, ignoreReason(RuleGitignore, rule)
+		}
+	}
+	if s.ignoreFile != nil {
+		if rule := s.ignoreFile.match(relativePath); rule != nil {
+			return false, ignoreReason(RuleIgnoreFile, rule)
+		}
+	}
+	if classified, why := s.classifier.byName(relativePath); classified {
+		return false, why
+	}
+	return true, reason
+}
+
+// sniff reads the head of a file to exclude binary files and the
+// classifier's content categories, and records the encoding of text files.
+// It only reads shared state, so several files can be sniffed at once.
+func (s *scanner) sniff(file *File) (bool, Reason) {
+	relativePath := file.Path
+	head, err := readHead(s.tree.FS, relativePath, sniffSize)
+	if err != nil {
+		return true, Reason{Rule: RuleBinary}
+	}
+	encoding, text := detectEncoding(head)
+	if !text {
+		return true, Reason{Rule: RuleBinary}
+	}
+	file.Encoding = encoding
+	if encoding != "" {
+		// Classify the text, not its encoded bytes
+		if decoded, err := transcode(head, encoding); err == nil {
+			head = decoded
+		}
+	}
+	if classified, why := s.classifier.byContent(relativePath, head, file.Size); classified {
+		return true, why
+	}
+	return false, Reason{}
+}
+
+// applyBudget moves the included files that would take the estimated
+// token count over opts.MaxTokens to the excluded set
+func (s *Selection) applyBudget(fsys fs.FS, opts Options) {
+	var kept []File
+	tokens := 0
+	fsFor := func(File) fs.FS { return fsys }
+	readFiles(s.Included, fsFor, opts, StageBudget, func(file File, content []byte, err error) error {
+		if err == nil {
+			if n := EstimateTokens(string(content)); tokens+n <= opts.MaxTokens {
+				tokens += n
+				kept = append(kept, file)
+				return nil
+			}
+		}
+		s.Excluded = append(s.Excluded, file)
+		s.Reasons[file.Path] = Reason{Rule: RuleTokenBudget}
+		return nil
+	})
+	s.Included = kept
+	sort.SliceStable(s.Excluded, func(i, j int) bool { return s.Excluded[i].Path < s.Excluded[j].Path })
+}
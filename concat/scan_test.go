@@ -0,0 +1,57 @@
+package concat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExclusionCounts(t *testing.T) {
+	binary := Reason{Rule: RuleBinary}
+	lockfile := Reason{Rule: RuleLockfile}
+	defaults := Reason{Rule: RuleDefault}
+	a := &Selection{
+		Excluded: []File{{Path: "a.png"}, {Path: "go.sum"}},
+		Reasons: map[string]Reason{
+			"main.go":       {Rule: RuleIncluded},
+			"a.png":         binary,
+			"go.sum":        lockfile,
+			"node_modules/": defaults,
+		},
+	}
+	b := &Selection{
+		Excluded: []File{{Path: "b.png"}, {Path: "c.png"}, {Path: "yarn.lock"}},
+		Reasons: map[string]Reason{
+			"b.png":     binary,
+			"c.png":     binary,
+			"yarn.lock": lockfile,
+		},
+	}
+
+	tests := []struct {
+		name       string
+		selections []*Selection
+		want       []ReasonCount
+	}{
+		{"none", nil, []ReasonCount{}},
+		{"ties by reason", []*Selection{a}, []ReasonCount{
+			{"binary", 1},
+			{"default exclusion (whole directory)", 1},
+			{"lockfile", 1},
+		}},
+		{"added up, most frequent first", []*Selection{a, b}, []ReasonCount{
+			{"binary", 3},
+			{"lockfile", 2},
+			{"default exclusion (whole directory)", 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExclusionCounts(tt.selections...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExclusionCounts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got, want := b.ExclusionCounts(), ExclusionCounts(b); !reflect.DeepEqual(got, want) {
+		t.Errorf("Selection.ExclusionCounts() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package concat
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Source resolves an input to a Tree that can be walked.
+type Source interface {
+	Resolve() (*Tree, error)
+}
+
+// SourceInfo describes where a tree came from. Renderers use it for the
+// output header.
+type SourceInfo struct {
+	// Name is a short display name, used for output file names.
+	Name string
+	// Label identifies the source in multi-source documents.
+	Label string
+	// Path is the local directory or archive as given.
+	Path string
+	// Repository is the host/owner/name of a remote repository.
+	Repository string
+	// URL is the location the tree was cloned from, if any.
+	URL string
+	// Ref is the requested branch, tag or commit and Commit the SHA it
+	// resolved to. Both are empty for local directories.
+	Ref    string
+	Commit string
+	// Archive is the file name of an archive source.
+	Archive string
+	// Subdir is the directory or file a browser URL pointed at, if any.
+	// Scan only selects files within it.
+	Subdir string
+}
+
+// Describe summarises the location, ref and commit of the source in one
+// line, for example "github.com/org/api (ref main, commit 3f2c1e9...)".
+func (s SourceInfo) Describe() string {
+	location := s.Repository
+	if location == "" {
+		location = s.Path
+	}
+	if s.Subdir != "" {
+		location += "/" + s.Subdir
+	}
+
+	var details []string
+	if s.Ref != "" {
+		details = append(details, "ref "+s.Ref)
+	}
+	if s.Commit != "" {
+		details = append(details, "commit "+s.Commit)
+	}
+	if len(details) == 0 {
+		return location
+	}
+	return location + " (" + strings.Join(details, ", ") + ")"
+}
+
+// Tree is a resolved source, ready to be walked.
+type Tree struct {
+	SourceInfo
+
+	// Root is the on-disk directory backing FS.
+	Root string
+	FS   fs.FS
+
+	cleanup func() error
+}
+
+// Close releases temporary files held by the tree.
+func (t *Tree) Close() error {
+	if t.cleanup == nil {
+		return nil
+	}
+	err := t.cleanup()
+	t.cleanup = nil
+	return err
+}
+
+// LocalSource is a directory on the local file system.
+type LocalSource struct {
+	Path string
+}
+
+// Resolve implements Source.
+func (s LocalSource) Resolve() (*Tree, error) {
+	if _, err := os.Stat(s.Path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("local directory does not exist: %s", s.Path)
+	}
+
+	name := filepath.Base(s.Path)
+	if abs, err := filepath.Abs(s.Path); err == nil {
+		name = filepath.Base(abs)
+	}
+
+	return &Tree{
+		SourceInfo: SourceInfo{Name: name, Path: s.Path},
+		Root:       s.Path,
+		FS:         os.DirFS(s.Path),
+	}, nil
+}
@@ -0,0 +1,240 @@
+package concat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultTemplate is the template MarkdownRenderer executes, and the
+// starting point for custom templates.
+const DefaultTemplate = `# Repository Concatenation
+# Generated on: {{.Generated.Format "2006-01-02 15:04:05"}}
+{{if gt (len .Sources) 1 -}}
+# Sources:
+{{range .Sources}}#   {{.Label}}: {{.Describe}}
+{{end}}{{end -}}
+{{with .Archive}}# Archive: {{.}}
+{{end -}}
+{{with .Repository}}# Repository: {{.}}
+{{end -}}
+{{with .Ref}}# Ref: {{.}}
+{{end -}}
+{{with .Commit}}# Commit: {{.}}
+{{end -}}
+{{with .Subdir}}# Path: {{.}}
+{{end -}}
+# Total files: {{len .Files}}
+
+{{with .Tree -}}
+# Directory tree (selected files only):
+{{.}}
+{{end -}}
+{{with .TOC -}}
+# Table of contents (line, estimated tokens, path):
+{{range .}}#   {{printf "%6d" .Line}} {{printf "%7s" (printf "~%d" .Tokens)}}  {{.Path}}
+{{end}}
+{{end -}}
+{{range .Contents -}}
+# File: {{.Path}}
+{{with .Encoding}}# Encoding: {{.}} (transcoded to UTF-8)
+{{end -}}
+{{$fence := fence .Content -}}
+{{$fence}}{{language .}}
+{{chomp .Content}}
+{{$fence}}
+
+{{end -}}
+`
+
+// TemplateData is what an output template is executed with.
+type TemplateData struct {
+	// SourceInfo describes the source of a single-source document and is
+	// empty when there are several.
+	SourceInfo
+	Sources   []SourceInfo
+	Generated time.Time
+	// Files lists every file in order, without content.
+	Files []TemplateFile
+	// Tree is the directory tree of Files, see RenderPathTree. It is only
+	// set with Options.Tree.
+	Tree string
+	// TOC lists the files with the line their section starts on and their
+	// estimated tokens. It is only set with Options.TOC.
+	TOC []TOCEntry
+
+	doc *Document
+	// lines and toc lay out the TOC while a pass writes nowhere
+	lines *lineCounter
+	toc   []TOCEntry
+}
+
+// TOCEntry is a line of the table of contents.
+type TOCEntry struct {
+	Path   string
+	Line   int
+	Tokens int
+}
+
+// TemplateFile is a file as a template sees it.
+type TemplateFile struct {
+	File
+	// Path is the path shown for the file, prefixed with its source's
+	// label in multi-source documents. .File.Path is the path in its tree.
+	Path string
+	// Content is the content as written. It is only set on the files
+	// TemplateData.Contents yields.
+	Content string
+}
+
+// errStopContents ends Document.Each when a template stops ranging
+var errStopContents = errors.New("stop")
+
+// Contents yields the files in order with their content, reading one at a
+// time. Files that cannot be read are reported as a warning and skipped.
+func (d *TemplateData) Contents() iter.Seq[TemplateFile] {
+	return func(yield func(TemplateFile) bool) {
+		d.doc.Each(func(file File, content []byte) error {
+			if d.lines != nil {
+				d.toc = append(d.toc, TOCEntry{Path: d.doc.Path(file), Line: d.lines.n + 1, Tokens: EstimateTokens(string(content))})
+			}
+			if !yield(TemplateFile{File: file, Path: d.doc.Path(file), Content: string(content)}) {
+				return errStopContents
+			}
+			return nil
+		})
+	}
+}
+
+// templateFuncs are the helper functions available to templates
+var templateFuncs = template.FuncMap{
+	// language is the Language of a file, such as "go"
+	"language": func(file TemplateFile) string { return Language(file.File.Path, []byte(file.Content)) },
+	// lines counts the lines of a content
+	"lines": func(content string) int {
+		n := strings.Count(content, "\n")
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			n++
+		}
+		return n
+	},
+	// tokens estimates the tokens of a content, see EstimateTokens
+	"tokens": EstimateTokens,
+	// size formats a byte count, see FormatSize
+	"size": FormatSize,
+	// tree draws the directory tree of files
+	"tree": func(files []TemplateFile) string {
+		paths := make([]string, len(files))
+		for i, file := range files {
+			paths[i] = file.Path
+		}
+		return RenderPathTree(paths)
+	},
+	// fence returns a code fence the content cannot close
+	"fence": func(content string) string { return fence([]byte(content)) },
+	// chomp removes one trailing newline, so that "{{chomp .Content}}\n"
+	// ends content with exactly one
+	"chomp": func(content string) string { return strings.TrimSuffix(content, "\n") },
+}
+
+// TemplateRenderer renders a Document with a text/template.
+type TemplateRenderer struct {
+	template *template.Template
+}
+
+// NewTemplateRenderer parses a text/template. It is executed with
+// TemplateData and can use these functions:
+//
+//	language FILE  the language of a file, as Language
+//	lines TEXT     the number of lines
+//	tokens TEXT    the estimated tokens, as EstimateTokens
+//	size BYTES     a readable size, as FormatSize
+//	tree FILES     the directory tree of files, such as .Files
+//	fence TEXT     a code fence longer than any backtick run in the text
+//	chomp TEXT     the text without one trailing newline
+//
+// File contents are read while the template ranges over .Contents, so
+// large documents are not held in memory.
+func NewTemplateRenderer(name, text string) (*TemplateRenderer, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateRenderer{template: tmpl}, nil
+}
+
+// LoadTemplate reads and parses a template file, see NewTemplateRenderer.
+func LoadTemplate(file string) (*TemplateRenderer, error) {
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+	r, err := NewTemplateRenderer(filepath.Base(file), string(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return r, nil
+}
+
+// Render implements Renderer.
+func (r *TemplateRenderer) Render(w io.Writer, doc *Document) error {
+	data := &TemplateData{
+		SourceInfo: doc.SourceInfo,
+		Sources:    doc.Sources,
+		Generated:  doc.Generated,
+		Tree:       doc.tree(),
+		doc:        doc,
+	}
+	for _, file := range doc.Files {
+		data.Files = append(data.Files, TemplateFile{File: file, Path: doc.Path(file)})
+	}
+	if doc.opts.TOC {
+		if err := r.layout(data); err != nil {
+			return err
+		}
+	}
+	return r.template.Execute(w, data)
+}
+
+// layout fills in data.TOC. Where a file starts depends on the template,
+// so the document is rendered without being written, with a placeholder
+// TOC of the same length, and the line count is taken as each file is
+// reached. Files that cannot be read drop out of the TOC, which then needs
+// another pass.
+func (r *TemplateRenderer) layout(data *TemplateData) error {
+	quiet := *data.doc
+	quiet.opts.Notify, quiet.opts.Progress = nil, nil
+	pass := *data
+	pass.doc = &quiet
+	for _, file := range data.Files {
+		pass.TOC = append(pass.TOC, TOCEntry{Path: file.Path})
+	}
+	for {
+		pass.lines, pass.toc = &lineCounter{}, nil
+		if err := r.template.Execute(pass.lines, &pass); err != nil {
+			return err
+		}
+		if len(pass.toc) == len(pass.TOC) {
+			data.TOC = pass.toc
+			return nil
+		}
+		pass.TOC = pass.toc
+	}
+}
+
+// lineCounter is a writer counting the lines written to it
+type lineCounter struct {
+	n int
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	c.n += bytes.Count(p, []byte("\n"))
+	return len(p), nil
+}
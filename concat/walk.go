@@ -0,0 +1,54 @@
+package concat
+
+import (
+	"io"
+	"io/fs"
+)
+
+// Walker visits the files of a file system in lexical order.
+type Walker struct {
+	FS fs.FS
+
+	// SkipDir reports whether the directory at the slash separated path
+	// should not be entered at all. It may be nil.
+	SkipDir func(path string) bool
+}
+
+// Walk calls fn for every non-directory entry below the root, pruning the
+// directories rejected by SkipDir.
+func (w Walker) Walk(fn func(File) error) error {
+	return fs.WalkDir(w.FS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != "." && w.SkipDir != nil && w.SkipDir(path) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		file := File{Path: path}
+		if info, err := d.Info(); err == nil {
+			file.Size = info.Size()
+			file.ModTime = info.ModTime()
+		}
+		return fn(file)
+	})
+}
+
+// readHead returns up to n bytes from the start of a file
+func readHead(fsys fs.FS, path string, n int) ([]byte, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buffer := make([]byte, n)
+	read, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return buffer[:read], nil
+}
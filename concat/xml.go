@@ -0,0 +1,107 @@
+package concat
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// XMLRenderer writes the document structure Claude is prompted with: a
+// <documents> element holding a <header> with the repository metadata and
+// one <document> per file, its content wrapped in CDATA.
+type XMLRenderer struct{}
+
+// xmlHeader is the metadata element of an XML document
+type xmlHeader struct {
+	XMLName         xml.Name     `xml:"header"`
+	Generated       string       `xml:"generated"`
+	Sources         []sourceMeta `xml:"repository"`
+	FileCount       int          `xml:"file_count"`
+	EstimatedTokens int          `xml:"estimated_tokens"`
+	DirectoryTree   *xmlTree     `xml:"directory_tree,omitempty"`
+}
+
+// xmlTree is the directory tree of the selected files, kept readable in
+// CDATA
+type xmlTree struct {
+	Scope string `xml:"scope,attr"`
+	Tree  string `xml:",cdata"`
+}
+
+// Render implements Renderer.
+func (XMLRenderer) Render(w io.Writer, doc *Document) error {
+	header := xmlHeader{
+		Generated:       doc.Generated.Format("2006-01-02 15:04:05"),
+		Sources:         doc.sourceMeta(),
+		FileCount:       len(doc.Files),
+		EstimatedTokens: doc.EstimateTokens(),
+	}
+	if tree := doc.tree(); tree != "" {
+		header.DirectoryTree = &xmlTree{Scope: "selected files only", Tree: "\n" + tree}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("<documents>\n")
+	encoder := xml.NewEncoder(&b)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(header); err != nil {
+		return err
+	}
+	b.WriteString("\n")
+	if _, err := b.WriteTo(w); err != nil {
+		return err
+	}
+
+	index := 0
+	err := doc.Each(func(file File, content []byte) error {
+		index++
+		b.Reset()
+		fmt.Fprintf(&b, "<document index=\"%d\">\n<source>", index)
+		xml.EscapeText(&b, []byte(doc.Path(file)))
+		b.WriteString("</source>\n")
+		if file.Encoding != "" {
+			fmt.Fprintf(&b, "<encoding>%s</encoding>\n", file.Encoding)
+		}
+		b.WriteString("<document_content>")
+		writeCDATA(&b, content)
+		b.WriteString("</document_content>\n</document>\n")
+		_, err := b.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "</documents>\n")
+	return err
+}
+
+// writeCDATA wraps content in CDATA sections. A "]]>" inside content is
+// split across two sections, and characters XML cannot carry, such as most
+// control characters, become U+FFFD.
+func writeCDATA(b *bytes.Buffer, content []byte) {
+	b.WriteString("<![CDATA[")
+	for len(content) > 0 {
+		r, size := utf8.DecodeRune(content)
+		switch {
+		case bytes.HasPrefix(content, []byte("]]>")):
+			b.WriteString("]]]]><![CDATA[>")
+			size = 3
+		case r == utf8.RuneError && size == 1 || !isXMLChar(r):
+			b.WriteRune(utf8.RuneError)
+		default:
+			b.Write(content[:size])
+		}
+		content = content[size:]
+	}
+	b.WriteString("]]>")
+}
+
+// isXMLChar reports whether r may appear in an XML 1.0 document
+func isXMLChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
@@ -1,17 +1,12 @@
 package main
 
 import (
-	"crypto/md5"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 	"bufio"
@@ -20,39 +15,99 @@ import (
 	
 	"github.com/fatih/color"
 	"repo-concat/cli"
+	"repo-concat/concat"
 	"repo-concat/tui"
 )
 
 type Config struct {
-	githubURL    string
-	localPath    string
+	sources      []sourceSpec
+	ref          string
+	sshKey       string
+	credentials  string
 	exclusions   []string
 	inclusions   []string
+	noGitignore  bool
+	noConfig     bool
+	format       string
+	template     string
+	tree         bool
+	toc          bool
+	order        string
+	maxTokens    int
+	explain      string
+	maxFileSize  int64
+	maxTotalSize int64
+	oversize     concat.SizePolicy
+	allow        []concat.Category
+	parallelism  int
+	readAhead    int64
 	peek         bool
 	outputDir    string
 	tokenEst     bool
 	enableTUI    bool
-}
 
-type CacheEntry struct {
-	URL        string    `json:"url"`
-	CachedAt   time.Time `json:"cached_at"`
-	RepoPath   string    `json:"repo_path"`
-	ExpiresAt  time.Time `json:"expires_at"`
+	// stdout receives the document with -output -
+	stdout io.Writer
+	// status receives status messages, progress and prompts: stdout, or
+	// stderr when the document goes to stdout
+	status io.Writer
 }
 
+// sourceSpec is one -url or -path argument, kept in command line order
+type sourceSpec struct {
+	isURL bool
+	value string
+}
 
 func main() {
 	var config Config
 	var exclusionFlags stringSlice
 	var inclusionFlags stringSlice
 
-	flag.StringVar(&config.githubURL, "url", "", "GitHub repository URL")
-	flag.StringVar(&config.localPath, "path", "", "Local directory path")
-	flag.Var(&exclusionFlags, "exclude", "Regex patterns or path patterns (/dir) to exclude files (can be used multiple times)")
-	flag.Var(&inclusionFlags, "include", "Regex patterns or path patterns (/dir) to include files (if specified, only matching files are included)")
+	flag.Var(sourceFlag{&config.sources, true}, "url", "Repository URL (HTTPS, SSH, git@host:org/repo, file://) or browser URL (can be used multiple times)")
+	flag.StringVar(&config.ref, "ref", "", "Branch, tag or commit to check out (with a single -url)")
+	flag.StringVar(&config.sshKey, "ssh-key", "", "Private key for SSH repository URLs (default: ssh agent and ssh config)")
+	flag.StringVar(&config.credentials, "credentials", "", "Credentials file with 'host token [username]' lines (default: "+concat.DefaultCredentialsFile()+")")
+	flag.Var(sourceFlag{&config.sources, false}, "path", "Local directory path or archive (.zip, .tar, .tar.gz, .tgz, .tar.zst) (can be used multiple times)")
+	flag.Var(&exclusionFlags, "exclude", "Patterns of files to exclude: regex, glob (*.go), path (/dir/) or gitignore:<rule>; prefix regex: or glob: to force the syntax (can be used multiple times)")
+	flag.Var(&inclusionFlags, "include", "Patterns of files to include, in the same syntax as -exclude (if specified, only matching files are included)")
+	flag.BoolVar(&config.noGitignore, "no-gitignore", false, "Include files ignored by .gitignore and .git/info/exclude")
+	flag.BoolVar(&config.noConfig, "no-config", false, "Ignore .repoconcat.yaml and .repoconcatignore in the sources")
+	flag.StringVar(&config.format, "format", "", "Output format: "+strings.Join(concat.Formats, ", ")+" (default: markdown)")
+	flag.StringVar(&config.template, "template", "", "Go text/template file rendering the output, instead of -format")
+	flag.BoolVar(&config.tree, "tree", false, "Start the output with a directory tree of the selected files")
+	flag.BoolVar(&config.toc, "toc", false, "Start the output with a table of contents giving each file's line and tokens")
+	flag.StringVar(&config.order, "order", "", "File order: "+strings.Join(concat.Orders, ", ")+" (default: path)")
+	flag.IntVar(&config.maxTokens, "max-tokens", 0, "Estimated token budget per source; files beyond it are skipped (0: no budget)")
+	flag.Func("max-file-size", "Largest file to include as is, e.g. 256KB (default: no limit)", func(value string) (err error) {
+		config.maxFileSize, err = concat.ParseSize(value)
+		return err
+	})
+	flag.Func("max-total-size", "Total size limit per source, e.g. 2MB (default: no limit)", func(value string) (err error) {
+		config.maxTotalSize, err = concat.ParseSize(value)
+		return err
+	})
+	flag.Func("oversize", "What to do with files over the size limits: skip, truncate or stub (default: skip)", func(value string) (err error) {
+		config.oversize, err = concat.ParseSizePolicy(value)
+		return err
+	})
+	allow := func(category concat.Category) func(string) error {
+		return func(string) error {
+			config.allow = append(config.allow, category)
+			return nil
+		}
+	}
+	flag.BoolFunc("include-lockfiles", "Include lockfiles such as package-lock.json and go.sum (skipped by default)", allow(concat.CategoryLockfile))
+	flag.BoolFunc("include-generated", "Include generated code, marked \"Code generated ... DO NOT EDIT.\" or linguist-generated (skipped by default)", allow(concat.CategoryGenerated))
+	flag.BoolFunc("include-minified", "Include minified files and source maps (skipped by default)", allow(concat.CategoryMinified))
+	flag.IntVar(&config.parallelism, "parallel", 0, "Files classified and read at once (default: number of CPUs)")
+	flag.Func("read-ahead", "Memory for file contents read ahead of the output, e.g. 16MB (default: 64MB)", func(value string) (err error) {
+		config.readAhead, err = concat.ParseSize(value)
+		return err
+	})
+	flag.StringVar(&config.explain, "explain", "", "Explain why a file is included or excluded, then exit")
 	flag.BoolVar(&config.peek, "peek", false, "Show folder structure and dry run before processing")
-	flag.StringVar(&config.outputDir, "output", ".", "Output directory for concatenated file")
+	flag.StringVar(&config.outputDir, "output", ".", "Output directory for concatenated file, or - for stdout")
 	flag.BoolVar(&config.tokenEst, "tokens", true, "Estimate token count")
 	flag.BoolVar(&config.enableTUI, "tui", false, "Enable modern TUI interface")
 
@@ -61,15 +116,57 @@ func main() {
 	config.exclusions = []string(exclusionFlags)
 	config.inclusions = []string(inclusionFlags)
 
+	var urls, paths []string
+	for _, spec := range config.sources {
+		if spec.isURL {
+			urls = append(urls, spec.value)
+		} else {
+			paths = append(paths, spec.value)
+		}
+	}
+
+	// With -output - the document is all that goes to stdout, and status
+	// messages and prompts go to stderr
+	config.stdout = os.Stdout
+	config.status = os.Stdout
+	if config.outputDir == "-" {
+		if config.enableTUI {
+			log.Fatal("-output - cannot be used with -tui")
+		}
+		config.status = os.Stderr
+	}
+
 	// Launch TUI mode if requested
 	if config.enableTUI {
+		var sources []tui.Source
+		for _, spec := range config.sources {
+			sources = append(sources, tui.Source{IsURL: spec.isURL, Value: spec.value})
+		}
 		tuiConfig := tui.Config{
-			URL:       config.githubURL,
-			Path:      config.localPath,
-			Include:   config.inclusions,
-			Exclude:   config.exclusions,
-			Output:    config.outputDir,
-			EnableTUI: true,
+			Sources:      sources,
+			URL:          strings.Join(urls, ","),
+			Ref:          config.ref,
+			SSHKey:       config.sshKey,
+			Credentials:  config.credentials,
+			Path:         strings.Join(paths, ","),
+			Include:      config.inclusions,
+			Exclude:      config.exclusions,
+			NoGitignore:  config.noGitignore,
+			NoConfig:     config.noConfig,
+			Format:       config.format,
+			Template:     config.template,
+			Tree:         config.tree,
+			TOC:          config.toc,
+			Order:        config.order,
+			MaxTokens:    config.maxTokens,
+			MaxFileSize:  config.maxFileSize,
+			MaxTotalSize: config.maxTotalSize,
+			SizePolicy:   config.oversize,
+			Allow:        config.allow,
+			Parallelism:  config.parallelism,
+			ReadAhead:    config.readAhead,
+			Output:       config.outputDir,
+			EnableTUI:    true,
 		}
 		
 		if err := tui.RunTUI(tuiConfig); err != nil {
@@ -79,18 +176,18 @@ func main() {
 		return
 	}
 
-	if config.githubURL == "" && config.localPath == "" {
-		fmt.Println(cli.ErrorMsg("Configuration Error", 
-			"Either GitHub URL or local directory path is required",
-			"Use -url for GitHub repositories or -path for local directories"))
+	if len(config.sources) == 0 {
+		fmt.Fprintln(config.status, cli.ErrorMsg("Configuration Error", 
+			"At least one repository URL or local path is required",
+			"Use -url for repositories or -path for local directories and archives; both can be repeated"))
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if config.githubURL != "" && config.localPath != "" {
-		fmt.Println(cli.ErrorMsg("Configuration Error", 
-			"Cannot specify both GitHub URL and local directory path",
-			"Use either -url OR -path, not both"))
+	if config.ref != "" && len(urls) != 1 {
+		fmt.Fprintln(config.status, cli.ErrorMsg("Configuration Error", 
+			"A ref can only be checked out from a single repository URL",
+			"Use -ref together with one -url, or browser URLs (/tree/<ref>) to pick a ref per repository"))
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -111,293 +208,374 @@ func (s *stringSlice) Set(value string) error {
 	return nil
 }
 
-func getTmpCacheDir() string {
-	return filepath.Join("/tmp", "repo-concat-cache")
-}
-
-func urlToHash(githubURL string) string {
-	hash := md5.Sum([]byte(githubURL))
-	return hex.EncodeToString(hash[:])
+// sourceFlag collects -url and -path values into one ordered list
+type sourceFlag struct {
+	sources *[]sourceSpec
+	isURL   bool
 }
 
-func formatDuration(d time.Duration) string {
-	if d < time.Second {
-		return "just now"
-	}
-	if d < time.Minute {
-		seconds := int(d.Seconds())
-		if seconds == 1 {
-			return "1 second"
-		}
-		return fmt.Sprintf("%d seconds", seconds)
+func (f sourceFlag) String() string {
+	if f.sources == nil {
+		return ""
 	}
-	if d < time.Hour {
-		minutes := int(d.Minutes())
-		if minutes == 1 {
-			return "1 minute"
+	var values []string
+	for _, spec := range *f.sources {
+		if spec.isURL == f.isURL {
+			values = append(values, spec.value)
 		}
-		return fmt.Sprintf("%d minutes", minutes)
-	}
-	hours := int(d.Hours())
-	if hours == 1 {
-		return "1 hour"
 	}
-	return fmt.Sprintf("%d hours", hours)
+	return strings.Join(values, ", ")
 }
 
-func getCachedRepo(githubURL string) (string, bool, time.Time, error) {
-	cacheDir := getTmpCacheDir()
-	urlHash := urlToHash(githubURL)
-	metadataPath := filepath.Join(cacheDir, urlHash+".json")
+func (f sourceFlag) Set(value string) error {
+	*f.sources = append(*f.sources, sourceSpec{isURL: f.isURL, value: value})
+	return nil
+}
 
-	// Check if metadata file exists
-	if _, err := os.Stat(metadataPath); os.IsNotExist(err) {
-		return "", false, time.Time{}, nil
+// notifyCLI returns a Notify callback printing status messages to w
+func notifyCLI(w io.Writer) func(level, message string) {
+	return func(level, message string) {
+		fmt.Fprintln(w, cli.StatusMsg(level, message))
 	}
+}
 
-	// Read metadata
-	data, err := os.ReadFile(metadataPath)
-	if err != nil {
-		return "", false, time.Time{}, err
+// progressCLI returns a progress callback redrawing one line per stage on
+// w, whenever the percentage changes. Unless w is a terminal it returns
+// nil, so that redirected output is not filled with partial lines.
+func progressCLI(w io.Writer) func(concat.ProgressEvent) {
+	terminal, ok := w.(*os.File)
+	if !ok {
+		return nil
 	}
-
-	var entry CacheEntry
-	if err := json.Unmarshal(data, &entry); err != nil {
-		return "", false, time.Time{}, err
+	if info, err := terminal.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
 	}
-
-	// Check if cache is still valid
-	if time.Now().After(entry.ExpiresAt) {
-		// Cache expired, clean up
-		os.Remove(metadataPath)
-		os.RemoveAll(entry.RepoPath)
-		return "", false, time.Time{}, nil
+	messages := map[concat.Stage]string{
+		concat.StageClassify: "Classifying files",
+		concat.StageBudget:   "Estimating tokens",
+		concat.StageRead:     "Reading files",
 	}
-
-	// Check if repo directory still exists
-	if _, err := os.Stat(entry.RepoPath); os.IsNotExist(err) {
-		// Repo directory missing, clean up metadata
-		os.Remove(metadataPath)
-		return "", false, time.Time{}, nil
+	shown := -1
+	return func(event concat.ProgressEvent) {
+		percentage := event.Done * 100 / event.Total
+		if percentage == shown && event.Done < event.Total {
+			return
+		}
+		shown = percentage
+		fmt.Fprint(w, "\r"+cli.Progress(event.Done, event.Total, messages[event.Stage]))
+		if event.Done == event.Total {
+			fmt.Fprintln(w)
+			shown = -1
+		}
 	}
+}
 
-	return entry.RepoPath, true, entry.CachedAt, nil
+func newSource(config Config, spec sourceSpec) concat.Source {
+	switch {
+	case spec.isURL:
+		return &concat.GitSource{
+			URL:    spec.value,
+			Ref:    config.ref,
+			Paths:  concat.SparsePaths(config.inclusions),
+			Auth:   concat.Auth{SSHKey: config.sshKey, CredentialsFile: config.credentials},
+			Stdout: config.status,
+			Stderr: os.Stderr,
+			Notify: notifyCLI(config.status),
+		}
+	case concat.IsArchive(spec.value):
+		return concat.ArchiveSource{Path: spec.value}
+	default:
+		return concat.LocalSource{Path: spec.value}
+	}
 }
 
-func cacheRepo(githubURL, repoPath string) error {
-	cacheDir := getTmpCacheDir()
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+func processRepository(config Config) error {
+	if _, err := concat.NewRenderer(config.format); err != nil {
 		return err
 	}
+	var template *concat.TemplateRenderer
+	if config.template != "" {
+		if config.format != "" {
+			return fmt.Errorf("-format and -template cannot be combined")
+		}
+		var err error
+		if template, err = concat.LoadTemplate(config.template); err != nil {
+			return err
+		}
+	}
+
+	var trees []*concat.Tree
+	for _, spec := range config.sources {
+		tree, err := newSource(config, spec).Resolve()
+		if err != nil {
+			return err
+		}
+		defer tree.Close()
+		trees = append(trees, tree)
 
-	urlHash := urlToHash(githubURL)
-	metadataPath := filepath.Join(cacheDir, urlHash+".json")
+		if tree.Archive != "" {
+			fmt.Fprintln(config.status, cli.StatusMsg("info", "Processing archive: "+spec.value))
+		} else if !spec.isURL {
+			fmt.Fprintln(config.status, cli.StatusMsg("info", "Processing local directory: "+spec.value))
+		}
+	}
+	if len(trees) > 1 {
+		concat.LabelTrees(trees)
+	}
 
-	entry := CacheEntry{
-		URL:       githubURL,
-		CachedAt:  time.Now(),
-		RepoPath:  repoPath,
-		ExpiresAt: time.Now().Add(5 * time.Minute),
+	opts := concat.Options{
+		Include:         config.inclusions,
+		Exclude:         config.exclusions,
+		NoGitignore:     config.noGitignore,
+		NoProjectConfig: config.noConfig,
+		Order:           config.order,
+		MaxTokens:       config.maxTokens,
+		MaxFileSize:     config.maxFileSize,
+		MaxTotalSize:    config.maxTotalSize,
+		SizePolicy:      config.oversize,
+		Allow:           config.allow,
+		Tree:            config.tree,
+		TOC:             config.toc,
+		Parallelism:     config.parallelism,
+		ReadAhead:       config.readAhead,
+		Notify:          notifyCLI(config.status),
+		Progress:        progressCLI(config.status),
 	}
 
-	data, err := json.Marshal(entry)
-	if err != nil {
-		return err
+	if config.explain != "" {
+		return explainFile(config.status, trees, opts, config.explain)
 	}
 
-	return os.WriteFile(metadataPath, data, 0644)
-}
+	var selections []*concat.Selection
+	if config.peek {
+		fmt.Fprintln(config.status)
+		fmt.Fprintln(config.status, cli.SimpleHeader("📋 Repository Preview"))
+		fmt.Fprintln(config.status)
 
-func processRepository(config Config) error {
-	var repoPath string
-	var shouldCleanup bool
-
-	// Handle local directory path
-	if config.localPath != "" {
-		// Validate local path exists
-		if _, err := os.Stat(config.localPath); os.IsNotExist(err) {
-			return fmt.Errorf("local directory does not exist: %s", config.localPath)
-		}
-		fmt.Println(cli.StatusMsg("info", "Processing local directory: "+config.localPath))
-		repoPath = config.localPath
-		shouldCleanup = false
-	} else {
-		// Handle GitHub URL - check tmp cache first
-		if cachedPath, found, cachedAt, err := getCachedRepo(config.githubURL); err != nil {
-			fmt.Println(cli.StatusMsg("warning", fmt.Sprintf("Cache check failed: %v", err)))
-		} else if found {
-			age := time.Since(cachedAt)
-			fmt.Println(cli.StatusMsg("success", fmt.Sprintf("Using cached repository (cached %s ago)", formatDuration(age))))
-			repoPath = cachedPath
-			shouldCleanup = false
-		}
-
-		if repoPath == "" {
-			// No cache found, clone repository
-			tempDir, err := os.MkdirTemp("", "repo-concat-*")
+		var included, excluded int
+		for i, tree := range trees {
+			selection, err := concat.Scan(tree, opts)
 			if err != nil {
-				return fmt.Errorf("failed to create temp directory: %w", err)
+				return fmt.Errorf("failed to perform dry run: %w", err)
 			}
+			selections = append(selections, selection)
+			included += len(selection.Included)
+			excluded += len(selection.Excluded)
 
-			fmt.Println(cli.StatusMsg("loading", "Cloning repository: "+config.githubURL))
-			
-			if err := cloneRepository(config.githubURL, tempDir); err != nil {
-				os.RemoveAll(tempDir)
-				return fmt.Errorf("failed to clone repository: %w", err)
-			}
-			
-			fmt.Println(cli.StatusMsg("success", "Repository cloned successfully"))
-
-			repoName := extractRepoName(config.githubURL)
-			repoPath = filepath.Join(tempDir, repoName)
-			shouldCleanup = true
-
-			// Cache the repository in tmp
-			cacheDir := getTmpCacheDir()
-			if err := os.MkdirAll(cacheDir, 0755); err == nil {
-				cachedRepoPath := filepath.Join(cacheDir, urlToHash(config.githubURL))
-				if err := os.RemoveAll(cachedRepoPath); err == nil {
-					if err := os.Rename(repoPath, cachedRepoPath); err == nil {
-						repoPath = cachedRepoPath
-						shouldCleanup = false
-						if err := cacheRepo(config.githubURL, cachedRepoPath); err != nil {
-							color.Yellow("⚠️  Warning: failed to cache repository metadata: %v", err)
-						}
-					}
-				}
+			var relativeFiles []string
+			for _, file := range selection.Included {
+				relativeFiles = append(relativeFiles, file.Path)
 			}
 
-			if shouldCleanup {
-				defer os.RemoveAll(tempDir)
+			// Show simple tree with meaningful name
+			displayName := tree.Name
+			if !config.sources[i].isURL {
+				displayName = config.sources[i].value
 			}
-		}
-	}
-
-	if config.peek {
-		fmt.Println()
-		fmt.Println(cli.SimpleHeader("📋 Repository Preview"))
-		fmt.Println()
-		
-		dryRunFiles, excludedFiles, err := performDryRun(repoPath, config.exclusions, config.inclusions)
-		if err != nil {
-			return fmt.Errorf("failed to perform dry run: %w", err)
-		}
-
-		// Convert file paths to relative paths for cleaner display
-		var relativeFiles []string
-		for _, file := range dryRunFiles {
-			if rel, err := filepath.Rel(repoPath, file); err == nil {
-				relativeFiles = append(relativeFiles, rel)
-			} else {
-				relativeFiles = append(relativeFiles, file)
+			if tree.Label != "" {
+				displayName = tree.Label + ": " + tree.Describe()
 			}
+			fmt.Fprintln(config.status, cli.SimpleTree(displayName, relativeFiles, nil))
+			if files := selection.Config.Files; len(files) > 0 {
+				fmt.Fprintln(config.status, cli.StatusMsg("info", "Applied config: "+strings.Join(files, ", ")))
+			}
+			for _, file := range selection.Oversized() {
+				fmt.Fprintln(config.status, cli.StatusMsg("warning", fmt.Sprintf("%s (%s): %s", file.Path, concat.FormatSize(file.Size), selection.Reasons[file.Path])))
+			}
+			fmt.Fprintln(config.status)
 		}
-		
-		// Show simple tree with meaningful name
-		displayName := filepath.Base(repoPath)
-		if config.localPath != "" {
-			displayName = config.localPath
-		} else if config.githubURL != "" {
-			displayName = extractRepoName(config.githubURL)
-		}
-		fmt.Println(cli.SimpleTree(displayName, relativeFiles, nil))
-		fmt.Println()
 
 		// Simple summary
-		fmt.Println(cli.SimpleSummary(int64(len(dryRunFiles)), int64(len(excludedFiles)), 0))
-		fmt.Println()
-		
-		if len(dryRunFiles) == 0 {
-			fmt.Println(cli.StatusMsg("error", "No files would be included with current filters"))
+		fmt.Fprintln(config.status, cli.SimpleSummary(int64(included), int64(excluded), 0, concat.ExclusionCounts(selections...)))
+		fmt.Fprintln(config.status)
+
+		if included == 0 {
+			fmt.Fprintln(config.status, cli.StatusMsg("error", "No files would be included with current filters"))
 			return nil
 		}
 
 		// Simple confirmation
-		fmt.Print(cli.ConfirmPrompt(fmt.Sprintf("Proceed with concatenation of %d files?", len(dryRunFiles)))) 
-		fmt.Print(": ")
+		fmt.Fprint(config.status, cli.ConfirmPrompt(fmt.Sprintf("Proceed with concatenation of %d files?", included))) 
+		fmt.Fprint(config.status, ": ")
 		
 		reader := bufio.NewReader(os.Stdin)
 		response, _ := reader.ReadString('\n')
 		response = strings.TrimSpace(strings.ToLower(response))
 		if response != "y" && response != "yes" {
-			fmt.Println(cli.StatusMsg("warning", "Operation cancelled"))
+			fmt.Fprintln(config.status, cli.StatusMsg("warning", "Operation cancelled"))
 			return nil
 		}
 	}
 
-	fmt.Println(cli.StatusMsg("loading", "Collecting files..."))
-	files, err := collectFiles(repoPath, config.exclusions, config.inclusions)
-	if err != nil {
-		return fmt.Errorf("failed to collect files: %w", err)
+	if selections == nil {
+		fmt.Fprintln(config.status, cli.StatusMsg("loading", "Collecting files..."))
+		for _, tree := range trees {
+			selection, err := concat.Scan(tree, opts)
+			if err != nil {
+				return fmt.Errorf("failed to collect files: %w", err)
+			}
+			selections = append(selections, selection)
+		}
 	}
-	fmt.Println(cli.StatusMsg("success", fmt.Sprintf("Found %d files to process", len(files))))
 
-	var outputFileName string
-	if config.localPath != "" {
-		outputFileName = generateOutputFileNameForPath(config.localPath)
-	} else {
-		outputFileName = generateOutputFileName(config.githubURL)
+	// The -format and -template flags win over the format of the first
+	// source that sets one, and any source can ask for the tree or TOC
+	format := config.format
+	for _, selection := range selections {
+		if format == "" {
+			format = selection.Config.Format
+		}
+		opts.Tree = opts.Tree || selection.Config.Tree
+		opts.TOC = opts.TOC || selection.Config.TOC
 	}
-	
-	// Create output directory structure
-	outputSubDir := filepath.Join(config.outputDir, "repo-concat-output")
-	if err := os.MkdirAll(outputSubDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	renderer, err := concat.NewRenderer(format)
+	if err != nil {
+		return err
+	}
+	opts.Renderer = renderer
+	if template != nil {
+		opts.Renderer = template
 	}
-	
-	outputPath := filepath.Join(outputSubDir, outputFileName)
 
-	fmt.Println(cli.StatusMsg("loading", "Concatenating files..."))
-	content, err := concatenateFiles(files, repoPath)
+	var parts []concat.Part
+	var names []string
+	fileCount := 0
+	for i, tree := range trees {
+		parts = append(parts, concat.Part{Tree: tree, Files: selections[i].Included})
+		names = append(names, tree.Name)
+		fileCount += len(selections[i].Included)
+	}
+	fmt.Fprintln(config.status, cli.StatusMsg("success", fmt.Sprintf("Found %d files to process", fileCount)))
+
+	fmt.Fprintln(config.status, cli.StatusMsg("loading", "Concatenating files..."))
+	outputPath, tokenCount, copied, err := writeOutput(config, parts, opts, strings.Join(names, "+"))
 	if err != nil {
-		return fmt.Errorf("failed to concatenate files: %w", err)
+		return err
 	}
 
-	if err := os.WriteFile(outputPath, []byte(content), 0644); err != nil {
-		return fmt.Errorf("failed to write output file: %w", err)
+	fmt.Fprintln(config.status)
+	fmt.Fprintln(config.status, cli.Done(outputPath, fileCount, tokenCount))
+
+	switch {
+	case config.outputDir == "-":
+	case copied:
+		fmt.Fprintln(config.status, cli.StatusMsg("success", "Content copied to clipboard"))
+	default:
+		fmt.Fprintln(config.status, cli.StatusMsg("warning", "Could not copy to clipboard"))
+		fmt.Fprintln(config.status, cli.Subtle("  Install xclip (Linux) or use the output file above"))
 	}
 
-	var tokenCount int
+	return nil
+}
+
+// writeOutput streams the document to the output file and the clipboard,
+// or to stdout with -output -, counting tokens on the way. It returns
+// where the document went, its estimated tokens and whether it reached
+// the clipboard. When writing fails, the partial output file is removed
+// and the clipboard is left as it was.
+func writeOutput(config Config, parts []concat.Part, opts concat.Options, name string) (outputPath string, tokens int, copied bool, err error) {
+	outputPath = "stdout"
+	out := config.stdout
+	var file *os.File
+	var clipboard *clipboardWriter
+	if config.outputDir != "-" {
+		outputSubDir := filepath.Join(config.outputDir, "repo-concat-output")
+		if err := os.MkdirAll(outputSubDir, 0755); err != nil {
+			return "", 0, false, fmt.Errorf("failed to create output directory: %w", err)
+		}
+		path := filepath.Join(outputSubDir, concat.OutputFileName(name, time.Now()))
+		if file, err = os.Create(path); err != nil {
+			return "", 0, false, fmt.Errorf("failed to write output file: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				file.Close()
+				os.Remove(path)
+			}
+		}()
+		outputPath = path
+		out = file
+		if c, clipboardErr := startClipboard(); clipboardErr == nil {
+			clipboard = c
+			out = io.MultiWriter(out, clipboard)
+			defer func() {
+				if err != nil {
+					clipboard.abort()
+				}
+			}()
+		}
+	}
+	counter := &concat.TokenCounter{}
 	if config.tokenEst {
-		tokenCount = estimateTokens(content)
+		out = io.MultiWriter(out, counter)
 	}
-	
-	fmt.Println()
-	fmt.Println(cli.Done(outputPath, len(files), tokenCount))
 
-	if err := copyToClipboard(content); err != nil {
-		fmt.Println(cli.StatusMsg("warning", "Could not copy to clipboard"))
-		fmt.Println(cli.Subtle("  Install xclip (Linux) or use the output file above"))
-	} else {
-		fmt.Println(cli.StatusMsg("success", "Content copied to clipboard"))
+	w := bufio.NewWriter(out)
+	if err := concat.WriteParts(w, parts, opts); err != nil {
+		return "", 0, false, fmt.Errorf("failed to concatenate files: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return "", 0, false, fmt.Errorf("failed to write output: %w", err)
+	}
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return "", 0, false, fmt.Errorf("failed to write output file: %w", err)
+		}
 	}
 
-	return nil
+	copied = clipboard != nil && clipboard.Close() == nil
+	return outputPath, counter.Tokens(), copied, nil
 }
 
-func cloneRepository(githubURL, destDir string) error {
-	cmd := exec.Command("git", "clone", githubURL)
-	cmd.Dir = destDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// explainFile prints to w why a file is included or excluded. The path may be
+// relative to the source root, prefixed with a source label when there are
+// several sources, or a path on disk within a local source.
+func explainFile(w io.Writer, trees []*concat.Tree, opts concat.Options, target string) error {
+	for _, tree := range trees {
+		relativePath, ok := explainPath(tree, target, len(trees) > 1)
+		if !ok {
+			continue
+		}
+		selected, reason, err := concat.Explain(tree, opts, relativePath)
+		if err != nil {
+			return err
+		}
+		name := relativePath
+		if tree.Label != "" {
+			name = tree.Label + "/" + relativePath
+		}
+		if selected {
+			fmt.Fprintln(w, cli.StatusMsg("success", name+": "+reason.String()))
+		} else {
+			fmt.Fprintln(w, cli.StatusMsg("warning", name+": "+reason.String()))
+		}
+		return nil
+	}
+	return fmt.Errorf("%s is not in any source", target)
 }
 
-func extractRepoName(githubURL string) string {
-	parsedURL, err := url.Parse(githubURL)
-	if err != nil {
-		parts := strings.Split(githubURL, "/")
-		if len(parts) > 0 {
-			return strings.TrimSuffix(parts[len(parts)-1], ".git")
+// explainPath maps an -explain argument to a path relative to tree
+func explainPath(tree *concat.Tree, target string, labelled bool) (string, bool) {
+	if tree.Root != "" && tree.Archive == "" {
+		if _, err := os.Stat(target); err == nil {
+			root, rootErr := filepath.Abs(tree.Root)
+			abs, absErr := filepath.Abs(target)
+			if rootErr == nil && absErr == nil {
+				if rel, err := filepath.Rel(root, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+					return filepath.ToSlash(rel), true
+				}
+			}
 		}
-		return "repository"
 	}
 
-	parts := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
-	if len(parts) >= 2 {
-		return strings.TrimSuffix(parts[1], ".git")
+	target = strings.TrimPrefix(filepath.ToSlash(target), "./")
+	if !labelled {
+		return target, true
 	}
-	return "repository"
+	if rest, ok := strings.CutPrefix(target, tree.Label+"/"); ok {
+		return rest, true
+	}
+	return "", false
 }
 
 func showFilteredDirectoryStructure(rootPath string, relevantFiles []string, depth, maxDepth int) error {
@@ -487,307 +665,64 @@ func showDirectoryStructure(path string, depth, maxDepth int) error {
 	return nil
 }
 
-func isPathPattern(pattern string) bool {
-	return strings.HasPrefix(pattern, "/")
+// clipboardWriter feeds the system clipboard utility as the document is
+// written. Clipboard failures never fail the document itself: they are
+// kept and reported by Close.
+type clipboardWriter struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	err   error
 }
 
-func matchesPathPattern(pattern, relativePath string) bool {
-	if !isPathPattern(pattern) {
-		return false
-	}
-	
-	// Remove leading slash from pattern
-	cleanPattern := strings.TrimPrefix(pattern, "/")
-	
-	// Split path into components
-	pathParts := strings.Split(relativePath, string(filepath.Separator))
+func startClipboard() (*clipboardWriter, error) {
+	var cmd *exec.Cmd
 	
-	// For top-level directory matching, check if first component starts with pattern
-	if len(pathParts) > 0 {
-		return strings.HasPrefix(pathParts[0], cleanPattern)
+	switch {
+	case commandExists("pbcopy"):
+		cmd = exec.Command("pbcopy")
+	case commandExists("xclip"):
+		cmd = exec.Command("xclip", "-selection", "clipboard")
+	case commandExists("xsel"):
+		cmd = exec.Command("xsel", "--clipboard", "--input")
+	default:
+		return nil, fmt.Errorf("no clipboard utility found")
 	}
-	
-	return false
-}
 
-func matchesPattern(pattern, relativePath, baseName string) bool {
-	if isPathPattern(pattern) {
-		return matchesPathPattern(pattern, relativePath)
-	}
-	
-	// Regular regex matching
-	compiled, err := regexp.Compile(pattern)
+	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return false
-	}
-	return compiled.MatchString(relativePath) || compiled.MatchString(baseName)
-}
-
-func performDryRun(rootPath string, exclusionPatterns []string, inclusionPatterns []string) ([]string, []string, error) {
-	var includedFiles []string
-	var excludedFiles []string
-
-	// Validate regex patterns (skip path patterns starting with /)
-	for _, pattern := range exclusionPatterns {
-		if !isPathPattern(pattern) {
-			_, err := regexp.Compile(pattern)
-			if err != nil {
-				return nil, nil, fmt.Errorf("invalid exclusion regex pattern '%s': %w", pattern, err)
-			}
-		}
-	}
-
-	for _, pattern := range inclusionPatterns {
-		if !isPathPattern(pattern) {
-			_, err := regexp.Compile(pattern)
-			if err != nil {
-				return nil, nil, fmt.Errorf("invalid inclusion regex pattern '%s': %w", pattern, err)
-			}
-		}
+		return nil, err
 	}
-
-	defaultExclusionPatterns := []string{
-		`\.git/`,
-		`\.gitignore$`,
-		`\.DS_Store$`,
-		`node_modules/`,
-		`\.env$`,
-		`\.(jpg|jpeg|png|gif|svg|ico|bmp|tiff|webp)$`,
-		`\.(mp4|mov|avi|mkv|webm|flv)$`,
-		`\.(mp3|wav|flac|aac|ogg)$`,
-		`\.(zip|tar|gz|rar|7z|exe|dmg|pkg)$`,
-		`\.(pdf|doc|docx|xls|xlsx|ppt|pptx)$`,
+	if err := cmd.Start(); err != nil {
+		return nil, err
 	}
-
-	allExclusionPatterns := append(exclusionPatterns, defaultExclusionPatterns...)
-
-	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			return nil
-		}
-
-		relativePath, err := filepath.Rel(rootPath, path)
-		if err != nil {
-			return err
-		}
-
-		// Check if it's a text file first
-		if !isTextFile(path) {
-			excludedFiles = append(excludedFiles, path)
-			return nil
-		}
-
-		// Check exclusions
-		excluded := false
-		for _, pattern := range allExclusionPatterns {
-			if matchesPattern(pattern, relativePath, filepath.Base(path)) {
-				excludedFiles = append(excludedFiles, path)
-				excluded = true
-				break
-			}
-		}
-
-		if excluded {
-			return nil
-		}
-
-		// If inclusions are specified, file must match at least one inclusion pattern
-		if len(inclusionPatterns) > 0 {
-			matched := false
-			for _, pattern := range inclusionPatterns {
-				if matchesPattern(pattern, relativePath, filepath.Base(path)) {
-					matched = true
-					break
-				}
-			}
-			if !matched {
-				excludedFiles = append(excludedFiles, path)
-				return nil
-			}
-		}
-
-		includedFiles = append(includedFiles, path)
-		return nil
-	})
-
-	return includedFiles, excludedFiles, err
+	return &clipboardWriter{cmd: cmd, stdin: stdin}, nil
 }
 
-func collectFiles(rootPath string, exclusionPatterns []string, inclusionPatterns []string) ([]string, error) {
-	var files []string
-
-	// Validate regex patterns (skip path patterns starting with /)
-	for _, pattern := range exclusionPatterns {
-		if !isPathPattern(pattern) {
-			_, err := regexp.Compile(pattern)
-			if err != nil {
-				return nil, fmt.Errorf("invalid exclusion regex pattern '%s': %w", pattern, err)
-			}
-		}
-	}
-
-	for _, pattern := range inclusionPatterns {
-		if !isPathPattern(pattern) {
-			_, err := regexp.Compile(pattern)
-			if err != nil {
-				return nil, fmt.Errorf("invalid inclusion regex pattern '%s': %w", pattern, err)
-			}
-		}
-	}
-
-	defaultExclusionPatterns := []string{
-		`\.git/`,
-		`\.gitignore$`,
-		`\.DS_Store$`,
-		`node_modules/`,
-		`\.env$`,
-		`\.(jpg|jpeg|png|gif|svg|ico|bmp|tiff|webp)$`,
-		`\.(mp4|mov|avi|mkv|webm|flv)$`,
-		`\.(mp3|wav|flac|aac|ogg)$`,
-		`\.(zip|tar|gz|rar|7z|exe|dmg|pkg)$`,
-		`\.(pdf|doc|docx|xls|xlsx|ppt|pptx)$`,
+func (c *clipboardWriter) Write(p []byte) (int, error) {
+	if c.err == nil {
+		_, c.err = c.stdin.Write(p)
 	}
-
-	allExclusionPatterns := append(exclusionPatterns, defaultExclusionPatterns...)
-
-	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			return nil
-		}
-
-		relativePath, err := filepath.Rel(rootPath, path)
-		if err != nil {
-			return err
-		}
-
-		// Check exclusions first
-		for _, pattern := range allExclusionPatterns {
-			if matchesPattern(pattern, relativePath, filepath.Base(path)) {
-				return nil
-			}
-		}
-
-		// If inclusions are specified, file must match at least one inclusion pattern
-		if len(inclusionPatterns) > 0 {
-			matched := false
-			for _, pattern := range inclusionPatterns {
-				if matchesPattern(pattern, relativePath, filepath.Base(path)) {
-					matched = true
-					break
-				}
-			}
-			if !matched {
-				return nil
-			}
-		}
-
-		if isTextFile(path) {
-			files = append(files, path)
-		}
-
-		return nil
-	})
-
-	return files, err
+	return len(p), nil
 }
 
-func isTextFile(path string) bool {
-	file, err := os.Open(path)
-	if err != nil {
-		return false
-	}
-	defer file.Close()
-
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
-		return false
-	}
-
-	for i := 0; i < n; i++ {
-		if buffer[i] == 0 {
-			return false
-		}
+// Close ends the input and waits for the clipboard utility
+func (c *clipboardWriter) Close() error {
+	closeErr := c.stdin.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return err
 	}
-
-	return true
-}
-
-func concatenateFiles(files []string, rootPath string) (string, error) {
-	var result strings.Builder
-	
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	result.WriteString(fmt.Sprintf("# Repository Concatenation\n"))
-	result.WriteString(fmt.Sprintf("# Generated on: %s\n", timestamp))
-	result.WriteString(fmt.Sprintf("# Total files: %d\n\n", len(files)))
-
-	for _, filePath := range files {
-		relativePath, err := filepath.Rel(rootPath, filePath)
-		if err != nil {
-			relativePath = filePath
-		}
-
-		content, err := os.ReadFile(filePath)
-		if err != nil {
-			fmt.Printf("Warning: failed to read file %s: %v\n", relativePath, err)
-			continue
-		}
-
-		result.WriteString(fmt.Sprintf("# File: %s\n", relativePath))
-		result.WriteString("```\n")
-		result.Write(content)
-		if !strings.HasSuffix(string(content), "\n") {
-			result.WriteString("\n")
-		}
-		result.WriteString("```\n\n")
+	if c.err != nil {
+		return c.err
 	}
-
-	return result.String(), nil
-}
-
-
-
-func generateOutputFileName(githubURL string) string {
-	repoName := extractRepoName(githubURL)
-	timestamp := time.Now().Format("20060102-150405")
-	return fmt.Sprintf("%s-concat-%s.txt", repoName, timestamp)
+	return closeErr
 }
 
-func generateOutputFileNameForPath(localPath string) string {
-	dirName := filepath.Base(localPath)
-	timestamp := time.Now().Format("20060102-150405")
-	return fmt.Sprintf("%s-concat-%s.txt", dirName, timestamp)
-}
-
-func estimateTokens(content string) int {
-	words := strings.Fields(content)
-	return len(words) * 4 / 3
-}
-
-func copyToClipboard(content string) error {
-	var cmd *exec.Cmd
-	
-	switch {
-	case commandExists("pbcopy"):
-		cmd = exec.Command("pbcopy")
-	case commandExists("xclip"):
-		cmd = exec.Command("xclip", "-selection", "clipboard")
-	case commandExists("xsel"):
-		cmd = exec.Command("xsel", "--clipboard", "--input")
-	default:
-		return fmt.Errorf("no clipboard utility found")
-	}
-
-	cmd.Stdin = strings.NewReader(content)
-	return cmd.Run()
+// abort stops the clipboard utility before it takes the partial input, and
+// waits for it
+func (c *clipboardWriter) abort() {
+	c.cmd.Process.Kill()
+	c.stdin.Close()
+	c.cmd.Wait()
 }
 
 func commandExists(cmdName string) bool {
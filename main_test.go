@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"repo-concat/concat"
+)
+
+func TestWriteOutputRemovesPartialFile(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.go"), []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := concat.LocalSource{Path: src}.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	selection, err := concat.Scan(tree, concat.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The template fails after writing part of the document
+	renderer, err := concat.NewTemplateRenderer("broken", `{{range .Files}}{{.Path}}{{end}}{{template "missing"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	config := Config{outputDir: out, status: os.Stderr}
+	parts := []concat.Part{{Tree: tree, Files: selection.Included}}
+	if _, _, _, err := writeOutput(config, parts, concat.Options{Renderer: renderer}, "src"); err == nil {
+		t.Fatal("writeOutput succeeded with a failing template")
+	}
+	entries, err := os.ReadDir(filepath.Join(out, "repo-concat-output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("left %s behind", entries[0].Name())
+	}
+}
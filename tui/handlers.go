@@ -2,10 +2,10 @@ package tui
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 	"strings"
 
+	"repo-concat/concat"
+
 	tea "github.com/charmbracelet/bubbletea"
 )
 
@@ -172,71 +172,66 @@ func (m Model) updateFocus() Model {
 
 func (m Model) updateConfigFromInputs() Model {
 	// Update config from input fields
-	m.config.URL = strings.TrimSpace(m.urlInput.Value())
-	m.config.Path = strings.TrimSpace(m.pathInput.Value())
-	
-	// Parse include patterns
-	includeStr := strings.TrimSpace(m.includeInput.Value())
-	if includeStr != "" {
-		m.config.Include = strings.Split(includeStr, ",")
-		for i := range m.config.Include {
-			m.config.Include[i] = strings.TrimSpace(m.config.Include[i])
-		}
-	} else {
-		m.config.Include = []string{}
+	url := strings.TrimSpace(m.urlInput.Value())
+	path := strings.TrimSpace(m.pathInput.Value())
+	if url != m.config.URL || path != m.config.Path {
+		m.config.Sources = nil
 	}
+	m.config.URL, m.config.Path = url, path
 	
-	// Parse exclude patterns
-	excludeStr := strings.TrimSpace(m.excludeInput.Value())
-	if excludeStr != "" {
-		m.config.Exclude = strings.Split(excludeStr, ",")
-		for i := range m.config.Exclude {
-			m.config.Exclude[i] = strings.TrimSpace(m.config.Exclude[i])
-		}
-	} else {
-		m.config.Exclude = []string{}
-	}
+	// Parse the pattern fields, keeping the command line's patterns while
+	// their field is unchanged
+	m.config.Include = parsePatterns(m.includeInput.Value(), m.config.Include)
+	m.config.Exclude = parsePatterns(m.excludeInput.Value(), m.config.Exclude)
 	
 	return m
 }
 
+// parsePatterns returns the patterns of an input field, or patterns when
+// the field still shows them as NewModel joined them
+func parsePatterns(value string, patterns []string) []string {
+	if value == strings.Join(patterns, ",") {
+		return patterns
+	}
+	return splitPatterns(value)
+}
+
 func (m Model) startPeek() tea.Cmd {
 	return func() tea.Msg {
-		// Resolve repository path (local or GitHub URL)
-		rootPath, err := resolveRepositoryPath(m.config)
+		// Resolve repository and perform a dry run to get files that would be included/excluded
+		trees, selections, err := scanRepository(m.config)
 		if err != nil {
 			return peekCompleteMsg{err: err}
 		}
+		defer closeTrees(trees)
 
-		// Perform dry run to get files that would be included/excluded
-		includedFiles, excludedFiles, err := performDryRun(rootPath, m.config.Exclude, m.config.Include)
-		if err != nil {
-			return peekCompleteMsg{err: fmt.Errorf("Failed to scan files: %v", err)}
-		}
-
-		// Convert to relative paths for display
 		var relIncluded []string
 		var relExcluded []string
+		var configFiles []string
+		var oversized []string
 
-		for _, filePath := range includedFiles {
-			if relPath, err := filepath.Rel(rootPath, filePath); err == nil {
-				relIncluded = append(relIncluded, relPath)
-			} else {
-				relIncluded = append(relIncluded, filePath)
+		for i, selection := range selections {
+			for _, file := range selection.Oversized() {
+				oversized = append(oversized, fmt.Sprintf("%s (%s): %s", displayPath(trees[i], file.Path), concat.FormatSize(file.Size), selection.Reasons[file.Path]))
+			}
+			for _, name := range selection.Config.Files {
+				configFiles = append(configFiles, displayPath(trees[i], name))
+			}
+			for _, file := range selection.Included {
+				relIncluded = append(relIncluded, displayPath(trees[i], file.Path))
 			}
-		}
 
-		for _, filePath := range excludedFiles {
-			if relPath, err := filepath.Rel(rootPath, filePath); err == nil {
-				relExcluded = append(relExcluded, relPath)
-			} else {
-				relExcluded = append(relExcluded, filePath)
+			for _, file := range selection.Excluded {
+				relExcluded = append(relExcluded, displayPath(trees[i], file.Path)+" ("+selection.Reasons[file.Path].String()+")")
 			}
 		}
 
 		return peekCompleteMsg{
 			includedFiles: relIncluded,
 			excludedFiles: relExcluded,
+			configFiles:   configFiles,
+			reasons:       concat.ExclusionCounts(selections...),
+			oversized:     oversized,
 			directoryTree: "", // Could add directory tree later
 			err:           nil,
 		}
@@ -245,83 +240,93 @@ func (m Model) startPeek() tea.Cmd {
 
 func (m Model) loadFiles() tea.Cmd {
 	return func() tea.Msg {
-		// Resolve repository path (local or GitHub URL)
-		rootPath, err := resolveRepositoryPath(m.config)
+		// Resolve repository and perform a dry run to get files that would be included/excluded
+		trees, selections, err := scanRepository(m.config)
 		if err != nil {
 			return errorMsg(err)
 		}
-
-		// Perform dry run to get files that would be included/excluded
-		includedFiles, excludedFiles, err := performDryRun(rootPath, m.config.Exclude, m.config.Include)
-		if err != nil {
-			return errorMsg(fmt.Errorf("Failed to scan files: %v", err))
-		}
+		defer closeTrees(trees)
 
 		var files []FileItem
-		
+
 		// Add included files
-		for _, filePath := range includedFiles {
-			info, err := os.Stat(filePath)
-			if err != nil {
-				continue // Skip files we can't stat
+		for i, selection := range selections {
+			for _, file := range selection.Included {
+				files = append(files, FileItem{
+					Path:     displayPath(trees[i], file.Path),
+					Size:     file.Size,
+					ModTime:  file.ModTime,
+					Selected: false,
+				})
 			}
-			
-			relPath, _ := filepath.Rel(rootPath, filePath)
-			files = append(files, FileItem{
-				Path:     relPath,
-				IsDir:    info.IsDir(),
-				Size:     info.Size(),
-				ModTime:  info.ModTime(),
-				Selected: false,
-			})
 		}
 
 		// Add some excluded files for context (marked as excluded)
-		for i, filePath := range excludedFiles {
-			if i >= 10 { // Limit to first 10 excluded files
-				break
+		shown := 0
+		for i, selection := range selections {
+			for _, file := range selection.Excluded {
+				if shown >= 10 { // Limit to first 10 excluded files
+					break
+				}
+				files = append(files, FileItem{
+					Path:     fmt.Sprintf("[EXCLUDED] %s", displayPath(trees[i], file.Path)),
+					Size:     file.Size,
+					ModTime:  file.ModTime,
+					Selected: false,
+				})
+				shown++
 			}
-			info, err := os.Stat(filePath)
-			if err != nil {
-				continue
-			}
-			
-			relPath, _ := filepath.Rel(rootPath, filePath)
-			files = append(files, FileItem{
-				Path:     fmt.Sprintf("[EXCLUDED] %s", relPath),
-				IsDir:    info.IsDir(),
-				Size:     info.Size(),
-				ModTime:  info.ModTime(),
-				Selected: false,
-			})
 		}
 
 		return filesLoadedMsg(files)
 	}
 }
 
+// startProcessing runs the processing in the background. Its status and
+// progress updates and finally its result arrive as messages from the
+// channel in processingStartedMsg, see waitForUpdate.
 func (m Model) startProcessing() tea.Cmd {
 	return func() tea.Msg {
-		statusCallback := func(status string) {
-			// TODO: Could send status updates via channels in future
-		}
+		updates := make(chan tea.Msg)
+		go func() {
+			defer close(updates)
 
-		progressCallback := func(progress float64) {
-			// TODO: Could send progress updates via channels in future  
-		}
+			statusCallback := func(status string) {
+				updates <- statusMsg(status)
+			}
 
-		// Process the repository using actual logic
-		files, tokens, outputFile, err := processRepositoryTUI(
-			m.config,
-			statusCallback,
-			progressCallback,
-		)
-
-		return processingCompleteMsg{
-			files:      files,
-			tokens:     tokens,
-			outputFile: outputFile,
-			err:        err,
-		}
+			// Only whole percents reach the model, so that workers finishing
+			// files wait on the UI at most a hundred times
+			shown := -1
+			progressCallback := func(progress float64) {
+				if percent := int(progress * 100); percent != shown {
+					shown = percent
+					updates <- progressMsg(progress)
+				}
+			}
+
+			// Process the repository using actual logic
+			files, tokens, outputFile, err := processRepositoryTUI(
+				m.config,
+				statusCallback,
+				progressCallback,
+			)
+
+			updates <- processingCompleteMsg{
+				files:      files,
+				tokens:     tokens,
+				outputFile: outputFile,
+				err:        err,
+			}
+		}()
+		return processingStartedMsg(updates)
+	}
+}
+
+// waitForUpdate returns the next message of the processing in the
+// background
+func waitForUpdate(updates <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-updates
 	}
 }
\ No newline at end of file
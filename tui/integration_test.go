@@ -0,0 +1,186 @@
+package tui
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestConfigSources(t *testing.T) {
+	ordered := []Source{{Value: "./a,b"}, {IsURL: true, Value: "https://github.com/acme/app"}, {Value: "sdk.tar.gz"}}
+	tests := []struct {
+		name   string
+		config Config
+		want   []Source
+	}{
+		{
+			name:   "command line order and commas kept",
+			config: Config{Sources: ordered, URL: "https://github.com/acme/app", Path: "./a,b,sdk.tar.gz"},
+			want:   ordered,
+		},
+		{
+			name:   "input fields",
+			config: Config{URL: "https://github.com/acme/app, https://gitlab.com/g/r", Path: "./a, sdk.tar.gz"},
+			want: []Source{
+				{IsURL: true, Value: "https://github.com/acme/app"},
+				{IsURL: true, Value: "https://gitlab.com/g/r"},
+				{Value: "./a"},
+				{Value: "sdk.tar.gz"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := configSources(tt.config); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("configSources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditingInputsReplacesSources(t *testing.T) {
+	m := NewModel(Config{Sources: []Source{{Value: "./a,b"}}, Path: "./a,b"})
+	if m = m.updateConfigFromInputs(); len(m.config.Sources) != 1 {
+		t.Fatalf("unchanged inputs dropped the sources: %v", m.config.Sources)
+	}
+	m.pathInput.SetValue("./c")
+	if m = m.updateConfigFromInputs(); m.config.Sources != nil || m.config.Path != "./c" {
+		t.Errorf("edited inputs kept sources %v, path %q", m.config.Sources, m.config.Path)
+	}
+}
+
+func TestProcessingSendsUpdates(t *testing.T) {
+	// Two sources, whose scans each report their own progress, and a
+	// token budget for the budget stage
+	var sources []Source
+	for range 2 {
+		src := t.TempDir()
+		for _, name := range []string{"a.go", "b.go", "c.md"} {
+			if err := os.WriteFile(filepath.Join(src, name), []byte("content of "+name+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sources = append(sources, Source{Value: src})
+	}
+	m := NewModel(Config{Sources: sources, MaxTokens: 1000, Output: t.TempDir()})
+	m.state = processingView
+
+	msg := m.startProcessing()()
+	var statuses []string
+	var percents []float64
+	for {
+		model, _ := m.Update(msg)
+		m = model.(Model)
+		if _, done := msg.(processingCompleteMsg); done {
+			break
+		}
+		switch msg := msg.(type) {
+		case statusMsg:
+			statuses = append(statuses, string(msg))
+		case progressMsg:
+			percents = append(percents, float64(msg))
+		}
+		if m.updates == nil {
+			t.Fatalf("no updates to wait for after %T", msg)
+		}
+		msg = waitForUpdate(m.updates)()
+	}
+
+	if m.err != nil || m.totalFiles != 6 || m.state != resultsView {
+		t.Fatalf("finished with err %v, %d files, state %v", m.err, m.totalFiles, m.state)
+	}
+	if len(statuses) == 0 || statuses[len(statuses)-1] != "Complete!" || m.statusMessage != "Complete!" {
+		t.Errorf("statuses %q, shown %q", statuses, m.statusMessage)
+	}
+	if len(percents) == 0 || percents[len(percents)-1] != 1 || m.progress != 1 {
+		t.Errorf("progress %v, shown %v", percents, m.progress)
+	}
+	if !sort.Float64sAreSorted(percents) {
+		t.Errorf("progress went back: %v", percents)
+	}
+}
+
+func TestResolveTreesRefNeedsOneURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"no URL", Config{Ref: "main", Path: t.TempDir()}},
+		{"several URLs", Config{Ref: "main", URL: "https://github.com/acme/app, https://github.com/acme/sdk"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trees, err := resolveTrees(tt.config)
+			if err == nil {
+				closeTrees(trees)
+				t.Fatal("resolveTrees accepted a ref")
+			}
+			if !strings.Contains(err.Error(), "single repository URL") {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+}
+
+func TestSplitPatterns(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"*.go, *.md", []string{"*.go", "*.md"}},
+		{"*.{go,md}", []string{"*.{go,md}"}},
+		{"src/**/*.{ts,{tsx,jsx}}, vendor/", []string{"src/**/*.{ts,{tsx,jsx}}", "vendor/"}},
+		{`^a{1,3}\.txt$,b`, []string{`^a{1,3}\.txt$`, "b"}},
+		{`glob:\{,x`, []string{`glob:\{`, "x"}},
+		{" , ,", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := splitPatterns(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitPatterns(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditingInputsReplacesPatterns(t *testing.T) {
+	// A regex with a comma outside braces survives while its field is not
+	// edited
+	m := NewModel(Config{Include: []string{"*.{go,md}", "a,b"}, Exclude: []string{"vendor/"}})
+	if m = m.updateConfigFromInputs(); !reflect.DeepEqual(m.config.Include, []string{"*.{go,md}", "a,b"}) {
+		t.Fatalf("unchanged input changed the include patterns to %q", m.config.Include)
+	}
+	m.includeInput.SetValue("*.{go,md}, docs/")
+	m = m.updateConfigFromInputs()
+	if !reflect.DeepEqual(m.config.Include, []string{"*.{go,md}", "docs/"}) || !reflect.DeepEqual(m.config.Exclude, []string{"vendor/"}) {
+		t.Errorf("edited inputs gave include %q, exclude %q", m.config.Include, m.config.Exclude)
+	}
+}
+
+func TestProcessingRemovesPartialFile(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.go"), []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The template fails after writing part of the document
+	template := filepath.Join(t.TempDir(), "broken.tmpl")
+	if err := os.WriteFile(template, []byte(`{{range .Files}}{{.Path}}{{end}}{{template "missing"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+	config := Config{Sources: []Source{{Value: src}}, Template: template, Output: out}
+	if _, _, _, err := processRepositoryTUI(config, func(string) {}, func(float64) {}); err == nil {
+		t.Fatal("processing succeeded with a failing template")
+	}
+	entries, err := os.ReadDir(filepath.Join(out, "repo-concat-output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("left %s behind", entries[0].Name())
+	}
+}
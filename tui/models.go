@@ -7,6 +7,9 @@ import (
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"repo-concat/concat"
 )
 
 type sessionState int
@@ -19,13 +22,40 @@ const (
 	resultsView
 )
 
+// Source is a repository URL, or a local directory or archive path
+type Source struct {
+	IsURL bool
+	Value string
+}
+
 type Config struct {
-	URL         string
-	Path        string
-	Include     []string
-	Exclude     []string
-	Output      string
-	EnableTUI   bool
+	// Sources lists the -url and -path arguments in command line order.
+	// URL and Path hold them comma separated for the input fields, and
+	// replace them once the fields are edited.
+	Sources      []Source
+	URL          string
+	Ref          string
+	SSHKey       string
+	Credentials  string
+	Path         string
+	Include      []string
+	Exclude      []string
+	NoGitignore  bool
+	NoConfig     bool
+	Format       string
+	Template     string
+	Tree         bool
+	TOC          bool
+	Order        string
+	MaxTokens    int
+	MaxFileSize  int64
+	MaxTotalSize int64
+	SizePolicy   concat.SizePolicy
+	Allow        []concat.Category
+	Parallelism  int
+	ReadAhead    int64
+	Output       string
+	EnableTUI    bool
 }
 
 type FileItem struct {
@@ -65,6 +95,9 @@ type Model struct {
 	// Peek data
 	includedFiles   []string
 	excludedFiles   []string
+	configFiles     []string
+	reasons         []concat.ReasonCount
+	oversized       []string
 	directoryTree   string
 	
 	// UI State
@@ -73,6 +106,7 @@ type Model struct {
 	processing      bool
 	progress        float64
 	statusMessage   string
+	updates         <-chan tea.Msg
 	
 	// Results
 	totalFiles      int
@@ -81,6 +115,8 @@ type Model struct {
 }
 
 type progressMsg float64
+type statusMsg string
+type processingStartedMsg <-chan tea.Msg
 type processingCompleteMsg struct {
 	files      int
 	tokens     int
@@ -91,6 +127,9 @@ type filesLoadedMsg []FileItem
 type peekCompleteMsg struct {
 	includedFiles []string
 	excludedFiles []string
+	configFiles   []string
+	reasons       []concat.ReasonCount
+	oversized     []string
 	directoryTree string
 	err           error
 }
@@ -107,4 +146,4 @@ func formatFileSize(size int64) string {
 		exp++
 	}
 	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
-}
\ No newline at end of file
+}
@@ -15,7 +15,7 @@ import (
 func NewModel(config Config) Model {
 	// Initialize input fields
 	urlInput := textinput.New()
-	urlInput.Placeholder = "https://github.com/user/repo"
+	urlInput.Placeholder = "https://github.com/user/repo (comma separated)"
 	urlInput.Focus()
 	urlInput.CharLimit = 200
 	urlInput.Width = 50
@@ -24,7 +24,7 @@ func NewModel(config Config) Model {
 	}
 
 	pathInput := textinput.New()
-	pathInput.Placeholder = "/path/to/local/repo"
+	pathInput.Placeholder = "/path/to/local/repo or archive (comma separated)"
 	pathInput.CharLimit = 200
 	pathInput.Width = 50
 	if config.Path != "" {
@@ -99,12 +99,27 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.fileList.SetHeight(msg.Height - 10)
 		return m, nil
 
+	case processingStartedMsg:
+		m.updates = msg
+		m.statusMessage = ""
+		return m, waitForUpdate(m.updates)
+
+	case statusMsg:
+		m.statusMessage = string(msg)
+		return m, waitForUpdate(m.updates)
+
 	case progressMsg:
 		m.progress = float64(msg)
 		cmd = m.progressBar.SetPercent(m.progress)
+		return m, tea.Batch(cmd, waitForUpdate(m.updates))
+
+	case progress.FrameMsg:
+		progressModel, cmd := m.progressBar.Update(msg)
+		m.progressBar = progressModel.(progress.Model)
 		return m, cmd
 
 	case processingCompleteMsg:
+		m.updates = nil
 		m.processing = false
 		m.totalFiles = msg.files
 		m.tokenCount = msg.tokens
@@ -125,6 +140,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case peekCompleteMsg:
 		m.includedFiles = msg.includedFiles
 		m.excludedFiles = msg.excludedFiles
+		m.configFiles = msg.configFiles
+		m.reasons = msg.reasons
+		m.oversized = msg.oversized
 		m.directoryTree = msg.directoryTree
 		m.err = msg.err
 		return m, nil
@@ -226,7 +244,12 @@ func (m Model) peekViewRender() string {
 	b.WriteString(RenderSuccess(fmt.Sprintf("Found %d files to include", len(m.includedFiles))))
 	b.WriteString("\n")
 	b.WriteString(RenderWarning(fmt.Sprintf("Found %d files to exclude", len(m.excludedFiles))))
-	b.WriteString("\n\n")
+	b.WriteString("\n")
+	if len(m.configFiles) > 0 {
+		b.WriteString(RenderStatus("Applied config: " + strings.Join(m.configFiles, ", ")))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
 
 	// Show sample included files
 	b.WriteString(RenderHeader("Files to Include:"))
@@ -243,6 +266,28 @@ func (m Model) peekViewRender() string {
 		b.WriteString("\n")
 	}
 
+	if len(m.oversized) > 0 {
+		b.WriteString("\n")
+		b.WriteString(RenderHeader("Size Limits:"))
+		b.WriteString("\n")
+		for _, file := range m.oversized {
+			b.WriteString("  ")
+			b.WriteString(RenderWarning(file))
+			b.WriteString("\n")
+		}
+	}
+
+	if len(m.reasons) > 0 {
+		b.WriteString("\n")
+		b.WriteString(RenderHeader("Excluded By Reason:"))
+		b.WriteString("\n")
+		for _, reason := range m.reasons {
+			b.WriteString(fmt.Sprintf("  %6d  ", reason.Count))
+			b.WriteString(RenderStatus(reason.Reason))
+			b.WriteString("\n")
+		}
+	}
+
 	if len(m.excludedFiles) > 0 {
 		b.WriteString("\n")
 		b.WriteString(RenderHeader("Sample Excluded Files:"))